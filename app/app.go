@@ -3,17 +3,18 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
-	"gopkg.in/validator.v2"
 )
 
 type TinyRPC struct {
@@ -23,20 +24,63 @@ type TinyRPC struct {
 	tsOutputLocation string
 	headerType       reflect.Type
 	appConstants     any
+	pathPrefix       string
+	middleware       []MiddlewareFn
+	prepareOnce      sync.Once
+	// Held while the app is prepared, and by anything that registers
+	// middleware, interceptors or routes, so they can't race with it
+	prepareMu          sync.Mutex
+	prepared           bool
+	tls                tlsSettings
+	serverOpts         serverOptions
+	httpStatus         func(Status) int
+	panicHook          PanicHook
+	batch              batchSettings
+	maxBodyBytes       int64
+	log                *slog.Logger
+	metrics            *metrics
+	tracing            SpanExporter
+	cors               *cors
+	interceptors       []InterceptorFn
+	socketQueryHeaders []string
+
+	// Lifecycle state, see shutdown.go
+	serverMu        sync.Mutex
+	server          *http.Server
+	shutdownHooks   []func(context.Context) error
+	shutdownTimeout time.Duration
+	active          activeRequests
+	// Cancelled on shutdown, as streams and sockets would otherwise hold it up
+	longLivedCtx  context.Context
+	stopLongLived context.CancelCauseFunc
 }
 
-func New(host string, tsOutputLocation string) *TinyRPC {
+func New(host string, tsOutputLocation string, opts ...Option) *TinyRPC {
 	router := chi.NewRouter()
 
-	return &TinyRPC{
+	c := &TinyRPC{
 		host:             host,
 		router:           router,
 		tsOutputLocation: tsOutputLocation,
+		shutdownTimeout:  defaultShutdownTimeout,
+		serverOpts:       defaultServerOptions(),
+		httpStatus:       Status.HTTPStatus,
+		batch:            batchSettings{maxCalls: defaultMaxBatchCalls},
+		maxBodyBytes:     defaultMaxBodyBytes,
 	}
+	c.longLivedCtx, c.stopLongLived = context.WithCancelCause(context.Background())
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 type ReturnError struct {
 	ErrorMessage string
+	Details      any              `json:",omitempty"`
+	Violations   []FieldViolation `json:",omitempty"`
+	// In milliseconds
+	RetryAfter int64 `json:",omitempty"`
 }
 
 type Res[T any] struct {
@@ -53,24 +97,71 @@ type RouteContainer struct {
 	HandleFn           func(context.Context, any) (any, error)
 	QueryPath          string
 	ChainedInterceptor []MiddlewareHandler
+	// The group the route was attached to, if any
+	Group *Group
+	// Whether the route is a regular request/ response, a stream or a socket
+	Kind RouteKind
+	// Set through WithHeaderType, in which case it's used instead of the
+	// group's or app's header type
+	HeaderType reflect.Type
+	// Set through WithMaxBodyBytes, otherwise the app's limit applies. Less
+	// than zero means no limit.
+	MaxBodyBytes int64
+	// Set through WithTimeout, zero means the route has no timeout of its own
+	Timeout time.Duration
 }
 
+type RouteKind int
+
+const (
+	RouteUnary RouteKind = iota
+	// Streams its output as server-sent events
+	RouteStream
+	// Upgrades to a WebSocket, with messages going both ways
+	RouteSocket
+)
+
 type Route[input any, output any] struct {
-	// A handler that matches the shape of the generic function
-	// but deals in bytes that are unmarshalled/ marshalled from/ to json
-	byteHandler func(context.Context, any) (any, error)
+	handler RouteHandler[input, output]
+	// Set through Intercept, and wrapped around the handler when the route is
+	// attached
+	interceptors []Interceptor[input, output]
+	// Set through Named, otherwise the name comes from the i/o structs
+	name string
+	// Set through WithHeaderType, WithMaxBodyBytes and WithTimeout
+	header       any
+	maxBodyBytes int64
+	timeout      time.Duration
 }
 
 func buildError(status Status, message string) ([]byte, error) {
+	return buildErrorResponse(&Error{Status: status, Message: message})
+}
+
+func buildErrorResponse(rpcErr *Error) ([]byte, error) {
 	res := Res[ReturnError]{
-		Status: status,
+		Status: rpcErr.Status,
 		Body: ReturnError{
-			ErrorMessage: message,
+			ErrorMessage: rpcErr.Message,
+			Details:      rpcErr.Details,
+			Violations:   rpcErr.Violations,
+			RetryAfter:   int64(math.Ceil(float64(rpcErr.RetryAfter) / float64(time.Millisecond))),
 		},
 	}
 	return writeResponse(res)
 }
 
+// Build the response for an error returned from a handler or middleware. If
+// there's an *Error anywhere in the chain its status is used, otherwise the
+// error is treated as internal
+func buildHandlerError(err error) ([]byte, error) {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return buildErrorResponse(rpcErr)
+	}
+	return buildError(STATUS_INTERNAL, err.Error())
+}
+
 func writeResponse[T any](res Res[T]) ([]byte, error) {
 	return json.Marshal(res)
 }
@@ -79,20 +170,26 @@ func writeResponse[T any](res Res[T]) ([]byte, error) {
 // embed the generic types in the unmarshal/ marshal
 func queryToByteHandlerAdapter[inputType any, outputType any](queryFunc func(context.Context, inputType) (outputType, error)) func(context.Context, any) (any, error) {
 	return func(ctx context.Context, input any) (any, error) {
+		// Record the status so the HTTP response code can be set from it
+		fail := func(err error) (any, error) {
+			setResponseStatus(ctx, StatusFromError(err))
+			return buildHandlerError(err)
+		}
+
 		var body inputType
-		err := json.Unmarshal(input.([]byte), &body)
+		err := decodeBody(ctx, input, &body)
 		if err != nil {
-			return buildError(STATUS_INVALID_ARGUMENT, err.Error())
+			return fail(err)
 		}
 
-		err = validator.Validate(body)
+		err = validateRequest(body)
 		if err != nil {
-			return buildError(STATUS_INVALID_ARGUMENT, err.Error())
+			return fail(err)
 		}
 
-		res, err := queryFunc(ctx, body)
-		if err != nil {
-			return buildError(STATUS_INTERNAL, err.Error())
+		res, err := runInterceptors(ctx, body, queryFunc)
+		if err = cancelledError(ctx, err); err != nil {
+			return fail(err)
 		}
 
 		responseObject := Res[outputType]{
@@ -103,23 +200,28 @@ func queryToByteHandlerAdapter[inputType any, outputType any](queryFunc func(con
 	}
 }
 
-func (p *Route[input, output]) AttachWithMiddleware(app *TinyRPC, headerMiddleware ...MiddlewareFn) {
-	rr, err := p.createRouteRep(headerMiddleware)
-	if err != nil {
+// Attach the route to the app or a Group, with middleware that only applies
+// to this route. Any group middleware runs before it. Panics if the route
+// can't be attached, see Register.
+func (p *Route[input, output]) AttachWithMiddleware(target RouteTarget, headerMiddleware ...MiddlewareFn) {
+	if err := p.Register(target, headerMiddleware...); err != nil {
 		panic(err)
 	}
-	app.AddHandler(rr)
 }
 
-// Just an alias for AttachWithMiddleware
-func (p *Route[input, output]) Attach(app *TinyRPC) {
-	p.AttachWithMiddleware(app)
+// Register is the same as AttachWithMiddleware, but returns an error rather
+// than panicking if the route can't be named or clashes with another route
+func (p *Route[input, output]) Register(target RouteTarget, headerMiddleware ...MiddlewareFn) error {
+	rr, err := p.createRouteRep(headerMiddleware)
+	if err != nil {
+		return err
+	}
+	return target.addRoute(rr)
 }
 
-func padString(str string, maxLength int) string {
-	// non-ascii characters take up more than one byte, whereas len(str) returns the number of bytes
-	actualLength := len([]rune(str))
-	return fmt.Sprintf("%s%*s", str, maxLength-actualLength, "")
+// Just an alias for AttachWithMiddleware
+func (p *Route[input, output]) Attach(target RouteTarget) {
+	p.AttachWithMiddleware(target)
 }
 
 type tinyRPCHeaderValue struct{}
@@ -128,54 +230,128 @@ var tinyRPCHeaderValueKey = tinyRPCHeaderValue{}
 
 // I want to inject the HTTP Headers into the context here
 func addHeadersToContext(ctx context.Context, headers http.Header) context.Context {
-	headerMap := make(map[string]string)
-	for key, value := range headers {
-		headerMap[key] = value[0]
-	}
-	return context.WithValue(ctx, tinyRPCHeaderValueKey, headerMap)
+	return context.WithValue(ctx, tinyRPCHeaderValueKey, headers)
 }
 
+func getHeaders(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(tinyRPCHeaderValueKey).(http.Header)
+	return headers
+}
+
+// GetHeader returns the first value of a request header, or an empty string if
+// it wasn't sent
 func GetHeader(ctx context.Context, key string) string {
-	headers := ctx.Value(tinyRPCHeaderValueKey)
-	if headers == nil {
-		return ""
-	}
-	canonicalKey := http.CanonicalHeaderKey(key)
-	return headers.(map[string]string)[canonicalKey]
+	return getHeaders(ctx).Get(key)
+}
+
+// GetHeaderValues returns every value of a request header
+func GetHeaderValues(ctx context.Context, key string) []string {
+	return getHeaders(ctx).Values(key)
 }
 
 // Take the RouteContainer and any header middleware, and return a standard HTTP handler
-func buildHandler(query *RouteContainer) func(http.ResponseWriter, *http.Request) {
+func (c *TinyRPC) buildHandler(query *RouteContainer) func(http.ResponseWriter, *http.Request) {
+	handleFn := c.chainMiddleware(query)
+	var queryHeaders map[string]bool
+	if query.Kind == RouteSocket {
+		queryHeaders = c.socketQueryHeaderNames(query)
+	}
+
 	return func(w http.ResponseWriter, req *http.Request) {
-		ctx := addHeadersToContext(req.Context(), req.Header)
+		headers := req.Header
+		if query.Kind == RouteSocket {
+			// Browsers can't set headers on WebSocket requests, so the client
+			// sends them as query parameters instead
+			headers = mergeQueryIntoHeaders(req, queryHeaders)
+		}
+		ctx := addHeadersToContext(req.Context(), headers)
+		ctx = addPeerCertificateToContext(ctx, req.TLS)
+		ctx = addClientIPToContext(ctx, req.RemoteAddr)
+		ctx, state := addResponseStateToContext(ctx, w.Header())
+		switch query.Kind {
+		case RouteStream:
+			state.stream = newStreamWriter(w, state)
+		case RouteSocket:
+			state.socket = newSocketUpgrader(w, req, state, c.routeBodyLimit(query))
+		}
+		if query.Kind != RouteUnary {
+			var cancel context.CancelCauseFunc
+			ctx, cancel = context.WithCancelCause(ctx)
+			defer cancel(nil)
+			stop := context.AfterFunc(c.longLivedCtx, func() {
+				cancel(context.Cause(c.longLivedCtx))
+			})
+			defer stop()
+		}
+		defer c.recoverPanic(ctx, w, query)
 
-		// Get request in the form of whatever, attempt to parse into expected structure
-		body, err := io.ReadAll(req.Body)
-		if err != nil {
-			jsonError, err := buildError(STATUS_INTERNAL, fmt.Sprintf("unable to read from body: %v", err))
+		if query.Kind != RouteSocket {
+			var cancel context.CancelFunc
+			var err error
+			ctx, cancel, err = withDeadline(ctx, query, headers)
+			defer cancel()
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Unable to create json body: %v", err), 500)
+				c.writeError(w, err)
 				return
 			}
-			w.Write(jsonError)
-			return
 		}
 
-		res, err := query.HandleFn(ctx, body)
+		// The route adapter decodes the body as it's read
+		body := limitBody(w, req, c.routeBodyLimit(query))
+		res, err := handleFn(ctx, body)
 		if err != nil {
-			jsonError, err := buildError(STATUS_INTERNAL, fmt.Sprintf("unable to execute handler: %v", err))
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Unable to create json body: %v", err), 500)
-				return
-			}
-			w.Write(jsonError)
+			c.failRequest(w, state, fmt.Errorf("unable to execute handler: %w", err))
+			return
+		}
+		if state.stream != nil && state.stream.isStarted() {
+			// The response has already been streamed to the client
+			return
+		}
+		if state.socket != nil && state.socket.upgraded() {
+			// The connection belongs to the socket now
 			return
 		}
 
+		code := c.httpStatus(state.status)
+		if state.httpStatus != 0 {
+			code = state.httpStatus
+		}
+		state.sendHeader(func() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+		})
 		w.Write(res.([]byte))
 	}
 }
 
+// Fail the request from outside the route adapter. A stream that's already
+// under way has sent its HTTP status, so the error becomes its last event.
+// Likewise an upgraded socket gets the error as its last message.
+func (c *TinyRPC) failRequest(w http.ResponseWriter, state *responseState, err error) {
+	if state != nil && state.stream != nil && state.stream.isStarted() {
+		state.stream.sendError(err)
+		return
+	}
+	if state != nil && state.socket != nil && state.socket.upgraded() {
+		state.socket.conn.closeWithError(err)
+		return
+	}
+	state.sendHeader(func() {
+		c.writeError(w, err)
+	})
+}
+
+func (c *TinyRPC) writeError(w http.ResponseWriter, err error) {
+	jsonError, marshalErr := buildHandlerError(err)
+	if marshalErr != nil {
+		http.Error(w, fmt.Sprintf("Unable to create json body: %v", marshalErr), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(c.errorHTTPStatus(err))
+	w.Write(jsonError)
+}
+
 func (c *TinyRPC) AddStaticDir(servePath string, dir string) {
 	root := http.Dir(dir)
 
@@ -199,37 +375,50 @@ func (c *TinyRPC) AddStaticDir(servePath string, dir string) {
 
 // Take the handlers and register them on the router
 func (c *TinyRPC) assembleHandlers() {
-	longestIndex := 0
-	for idx, query := range c.handlers {
-		f := buildHandler(query)
-		// We know that input and output types have to follow a particular pattern
-		// so we can assume if something is the longest route, it's also longest
-		// input and output
-		if len(query.QueryPath) > len(c.handlers[longestIndex].QueryPath) {
-			longestIndex = idx
+	if c.cors != nil {
+		c.prepareCORS()
+	}
+
+	for _, query := range c.handlers {
+		f := c.trackActive(query, c.buildHandler(query))
+		if query.Kind == RouteSocket {
+			c.router.Get(query.QueryPath, c.checkSocketOrigin(f))
+			continue
 		}
+		if c.cors != nil {
+			f = c.cors.wrap(f)
+			c.router.Options(query.QueryPath, c.cors.preflight)
+		}
+		c.router.Post(query.QueryPath, f)
+	}
 
-		c.router.Post(
-			query.QueryPath,
-			f,
-		)
+	if c.metrics != nil {
+		c.router.Get(c.metrics.path, c.metrics.handler)
 	}
 
-	// This'll be way more useful if I have the actual TS types at this point
-	for _, query := range c.handlers {
-		outputStr := padString(query.QueryPath, len(c.handlers[longestIndex].QueryPath))
-		paddedInputString := padString(query.InputType.Name(), len(c.handlers[longestIndex].InputType.Name()))
-		paddedOutputString := padString(query.OutputType.Name(), len(c.handlers[longestIndex].OutputType.Name()))
+	if c.batch.enabled {
+		batchRoute := &RouteContainer{FnName: "$batch", QueryPath: batchPath}
+		f := c.trackActive(batchRoute, c.buildBatchHandler())
+		if c.cors != nil {
+			f = c.cors.wrap(f)
+			c.router.Options(batchPath, c.cors.preflight)
+		}
+		c.router.Post(batchPath, f)
+	}
 
-		outputStr += fmt.Sprintf("\t [%s -> %s]", paddedInputString, paddedOutputString)
-		fmt.Println("Attaching: " + outputStr)
+	for _, query := range c.handlers {
+		c.logger().Debug("attached route",
+			"path", query.QueryPath,
+			"input", query.InputType.String(),
+			"output", query.OutputType.String(),
+		)
 	}
 }
 
 func (c *TinyRPC) writeCode() {
 	if c.tsOutputLocation == "" {
 		// Skip writing code out
-		fmt.Println("Not writing out code as tsOutputLocation is blank")
+		c.logger().Debug("not writing out code as tsOutputLocation is blank")
 		return
 	}
 
@@ -250,60 +439,157 @@ func (c *TinyRPC) writeCode() {
 	}
 }
 
-func notFoundHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Got not found request", r.URL)
-	body, err := buildError(STATUS_NOT_FOUND, "Not found")
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte("Page not found"))
-		return
+func (c *TinyRPC) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	c.logger().Debug("not found", "method", r.Method, "path", r.URL.Path)
+	c.writeError(w, NewError(STATUS_NOT_FOUND, "Not found"))
+}
+
+// Start assembles the handlers, writes out the generated code and then blocks
+// serving requests. It returns nil once the server has been stopped through
+// Shutdown, otherwise it returns whatever error stopped the listener.
+func (c *TinyRPC) Start() error {
+	c.prepare()
+
+	srv := c.httpServer()
+	listener := c.serverOpts.listener
+
+	addr := srv.Addr
+	if listener != nil {
+		addr = listener.Addr().String()
 	}
-	w.WriteHeader(404)
-	w.Write(body)
+	c.logger().Info("listening", "addr", addr, "tls", c.tls.enabled())
+
+	var err error
+	switch {
+	case listener != nil && c.tls.enabled():
+		err = srv.ServeTLS(listener, c.tls.certFile, c.tls.keyFile)
+	case listener != nil:
+		err = srv.Serve(listener)
+	case c.tls.enabled():
+		err = srv.ListenAndServeTLS(c.tls.certFile, c.tls.keyFile)
+	default:
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-func (c *TinyRPC) Start() {
-	start := time.Now()
-	c.assembleHandlers()
-	fmt.Printf("\nAssembled handlers in %v\n", time.Since(start))
-	c.writeCode()
-	fmt.Printf("%s %v\n\n", padString("Wrote code in", 21), time.Since(start))
+// Assemble the handlers and write out the generated code. This only happens
+// once, however the app ends up being served
+func (c *TinyRPC) prepare() {
+	c.prepareOnce.Do(func() {
+		c.prepareMu.Lock()
+		defer c.prepareMu.Unlock()
+		c.prepared = true
+		start := time.Now()
+		c.assembleHandlers()
+		assembled := time.Since(start)
+		c.writeCode()
+		c.logger().Info("prepared handlers",
+			"routes", len(c.handlers),
+			"assembled_in", assembled,
+			"total", time.Since(start),
+		)
 
-	c.router.NotFound(notFoundHandler)
+		c.router.NotFound(c.notFoundHandler)
+	})
+}
 
-	// todo: Handle SSL
-	addr := c.host
-	srv := &http.Server{
-		Handler:      c.router,
-		Addr:         addr,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+// Handler returns the app as a standard http.Handler, for mounting inside an
+// existing server rather than using Start. The handlers are assembled and the
+// code is written out on the first call.
+func (c *TinyRPC) Handler() http.Handler {
+	c.prepare()
+	return c
+}
+
+// ServeHTTP lets the app be used directly as an http.Handler. If a path prefix
+// has been set it's stripped from incoming requests, unless whatever the app is
+// mounted in has already done so.
+func (c *TinyRPC) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c.prepare()
+
+	if c.pathPrefix != "" && strings.HasPrefix(req.URL.Path, c.pathPrefix+"/") {
+		req = req.Clone(req.Context())
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, c.pathPrefix)
+		req.URL.RawPath = ""
 	}
 
-	fmt.Println("Listening on:", addr)
-	log.Fatal(srv.ListenAndServe())
+	c.router.ServeHTTP(w, req)
+}
+
+// SetPathPrefix sets the path the app is mounted at when it's embedded through
+// Handler, e.g. "/api". The prefix is added to the paths in the generated code.
+func (c *TinyRPC) SetPathPrefix(prefix string) {
+	c.pathPrefix = strings.TrimSuffix(prefix, "/")
+}
+
+// The server is created lazily, and shared between Start and Shutdown so that
+// a Shutdown that races ahead of Start still stops the server
+func (c *TinyRPC) httpServer() *http.Server {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+
+	if c.server == nil {
+		c.server = &http.Server{
+			Handler:           c,
+			Addr:              c.host,
+			ReadTimeout:       c.serverOpts.readTimeout,
+			ReadHeaderTimeout: c.serverOpts.readHeaderTimeout,
+			WriteTimeout:      c.serverOpts.writeTimeout,
+			IdleTimeout:       c.serverOpts.idleTimeout,
+			MaxHeaderBytes:    c.serverOpts.maxHeaderBytes,
+			ErrorLog:          c.errorLog(),
+		}
+		if c.tls.enabled() {
+			c.server.TLSConfig = c.tls.tlsConfig()
+		}
+	}
+	return c.server
 }
 
+// AddHeaderType sets the struct that the request headers are decoded into.
+// It's used for the headers parameter of the generated functions, and on the
+// server every request's headers are decoded and validated against it before
+// reaching the handler. Use Headers to get the decoded struct. Groups and
+// routes can set their own header type, which is used instead.
 func (c *TinyRPC) AddHeaderType(header any) {
 	if c.headerType != nil {
 		panic("Header type already set")
 	}
-	checkIfQueryStruct(header)
-
-	c.headerType = reflect.TypeOf(header)
+	headerType, err := resolveHeaderType(header)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.headerType = headerType
 }
 
 // I can use handlers to build up a collection of types to generate
 // Can I then also build the actual HTTP handlers
 func (c *TinyRPC) AddHandler(q *RouteContainer) {
+	if err := c.addHandler(q); err != nil {
+		panic(err.Error())
+	}
+}
+
+func (c *TinyRPC) addHandler(q *RouteContainer) error {
+	// Routes are only registered on the router once, when the app is prepared
+	c.prepareMu.Lock()
+	defer c.prepareMu.Unlock()
+	if c.prepared {
+		return fmt.Errorf("Route %s must be attached before the app is started", q.FnName)
+	}
 	// Check that there's not already another handler on the same route
 	for _, handler := range c.handlers {
 		if handler.QueryPath == q.QueryPath {
-			panic(fmt.Sprintf("Duplicate handler for route: %s", q.FnName))
+			return fmt.Errorf("Duplicate handler for route: %s", q.FnName)
 		}
 	}
 
 	c.handlers = append(c.handlers, q)
+	return nil
 }
 
 func (c *TinyRPC) GetAllMethodNames() []string {
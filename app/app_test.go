@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -28,7 +30,7 @@ func TestHandlerSomething(t *testing.T) {
 		newRoute := NewRoute(testFn)
 		rr, err := newRoute.createRouteRep(nil)
 		So(err, ShouldBeNil)
-		handler := buildHandler(rr)
+		handler := New("", "").buildHandler(rr)
 
 		Convey("with valid input", func() {
 			input := getDirContentsRequest{
@@ -67,6 +69,151 @@ func TestHandlerSomething(t *testing.T) {
 			_ = json.Unmarshal(body, &bodyRes)
 			So(bodyRes.Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
 			So(bodyRes.Body.ErrorMessage, ShouldContainSubstring, "Name: zero value")
+			So(bodyRes.Body.Violations, ShouldResemble, []FieldViolation{
+				{Field: "Name", Rule: "nonzero", Message: "zero value"},
+			})
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestAppAsHandler(t *testing.T) {
+	Convey("app can be served as a standard http.Handler", t, func() {
+		type echoRequest struct{ Name string }
+		type echoResponse struct{ Out string }
+
+		echoFn := func(ctx context.Context, req echoRequest) (*echoResponse, error) {
+			return &echoResponse{Out: req.Name}, nil
+		}
+
+		callEcho := func(url string) Res[echoResponse] {
+			res, err := http.Post(url, "application/json", bytes.NewBufferString(`{"Name": "testname"}`))
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+
+			var body Res[echoResponse]
+			So(json.NewDecoder(res.Body).Decode(&body), ShouldBeNil)
+			return body
+		}
+
+		Convey("through httptest", func() {
+			a := New("", "")
+			NewRoute(echoFn).Attach(a)
+			srv := httptest.NewServer(a.Handler())
+			defer srv.Close()
+
+			body := callEcho(srv.URL + "/tinyrpc/echo")
+			So(body.Status, ShouldEqual, STATUS_OK)
+			So(body.Body.Out, ShouldEqual, "testname")
+		})
+
+		Convey("mounted at a prefix in another mux", func() {
+			a := New("", "")
+			a.SetPathPrefix("/api/")
+			NewRoute(echoFn).Attach(a)
+
+			mux := http.NewServeMux()
+			mux.Handle("/api/", a.Handler())
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			body := callEcho(srv.URL + "/api/tinyrpc/echo")
+			So(body.Status, ShouldEqual, STATUS_OK)
+			So(body.Body.Out, ShouldEqual, "testname")
+
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, `"/api/tinyrpc/echo"`)
+		})
+	})
+}
+
+func TestHandlerErrors(t *testing.T) {
+	Convey("handler errors are returned with their status", t, func() {
+		type lookupRequest struct{ Name string }
+		type lookupResponse struct{ Out string }
+
+		a := New("", "")
+		lastCode := 0
+		callHandler := func(handleErr error, middleware ...MiddlewareFn) Res[ReturnError] {
+			testFn := func(ctx context.Context, req lookupRequest) (*lookupResponse, error) {
+				return nil, handleErr
+			}
+			rr, err := NewRoute(testFn).createRouteRep(middleware)
+			So(err, ShouldBeNil)
+
+			r, _ := http.NewRequest("POST", "/something", bytes.NewBufferString(`{"Name": "missing"}`))
+			w := httptest.NewRecorder()
+			a.buildHandler(rr)(w, r)
+			lastCode = w.Code
+
+			var bodyRes Res[ReturnError]
+			So(json.Unmarshal(w.Body.Bytes(), &bodyRes), ShouldBeNil)
+			return bodyRes
+		}
+
+		Convey("with a status error", func() {
+			bodyRes := callHandler(NewError(STATUS_NOT_FOUND, "no such thing"))
+			So(bodyRes.Status, ShouldEqual, STATUS_NOT_FOUND)
+			So(bodyRes.Body.ErrorMessage, ShouldEqual, "no such thing")
+			So(lastCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("with the HTTP status mapping overridden", func() {
+			a = New("", "", WithHTTPStatusMapping(func(s Status) int {
+				if s == STATUS_NOT_FOUND {
+					return http.StatusGone
+				}
+				return s.HTTPStatus()
+			}))
+			bodyRes := callHandler(NewError(STATUS_NOT_FOUND, "no such thing"))
+			So(bodyRes.Status, ShouldEqual, STATUS_NOT_FOUND)
+			So(lastCode, ShouldEqual, http.StatusGone)
+
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "case 410: return Status.STATUS_NOT_FOUND;")
+		})
+
+		Convey("with a wrapped status error and details", func() {
+			inner := NewErrorWithDetails(STATUS_PERMISSION_DENIED, "not yours", map[string]string{"Owner": "someone"})
+			bodyRes := callHandler(fmt.Errorf("checking owner: %w", inner))
+			So(bodyRes.Status, ShouldEqual, STATUS_PERMISSION_DENIED)
+			So(bodyRes.Body.ErrorMessage, ShouldEqual, "not yours")
+			So(bodyRes.Body.Details, ShouldResemble, map[string]any{"Owner": "someone"})
+		})
+
+		Convey("with a plain error", func() {
+			bodyRes := callHandler(errors.New("something broke"))
+			So(bodyRes.Status, ShouldEqual, STATUS_INTERNAL)
+			So(bodyRes.Body.ErrorMessage, ShouldEqual, "something broke")
+			So(lastCode, ShouldEqual, http.StatusInternalServerError)
+		})
+
+		Convey("with a status error from middleware", func() {
+			authMiddleware := func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+				return nil, NewError(STATUS_UNAUTHENTICATED, "missing token")
+			}
+			bodyRes := callHandler(nil, authMiddleware)
+			So(bodyRes.Status, ShouldEqual, STATUS_UNAUTHENTICATED)
+			So(bodyRes.Body.ErrorMessage, ShouldEqual, "missing token")
+			So(lastCode, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+
+	Convey("Errorf keeps the wrapped error", t, func() {
+		err := Errorf(STATUS_UNAVAILABLE, "calling upstream: %w", context.DeadlineExceeded)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(StatusFromError(err), ShouldEqual, STATUS_UNAVAILABLE)
+		So(StatusFromError(errors.New("plain")), ShouldEqual, STATUS_INTERNAL)
+		So(StatusFromError(nil), ShouldEqual, STATUS_OK)
+
+		Convey("including more than one", func() {
+			notFound := errors.New("not found")
+			err := Errorf(STATUS_UNAVAILABLE, "calling upstream: %w, then the cache: %w", context.DeadlineExceeded, notFound)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			So(errors.Is(err, notFound), ShouldBeTrue)
+			So(err.Error(), ShouldEqual, "STATUS_UNAVAILABLE: calling upstream: context deadline exceeded, then the cache: not found")
 		})
 	})
 }
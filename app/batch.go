@@ -0,0 +1,230 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Route names are identifiers, so this can't clash with any of them
+const batchPath = "/tinyrpc/$batch"
+
+const (
+	defaultBatchConcurrency = 8
+	defaultMaxBatchCalls    = 100
+)
+
+type batchSettings struct {
+	enabled     bool
+	concurrency int
+	maxCalls    int
+}
+
+// WithBatching enables the batch endpoint, which runs several calls in one
+// request. Concurrency is how many calls from the same batch are run at once,
+// if it's zero or less the default of 8 is used.
+func WithBatching(concurrency int) Option {
+	return func(c *TinyRPC) {
+		if concurrency <= 0 {
+			concurrency = defaultBatchConcurrency
+		}
+		c.batch.enabled = true
+		c.batch.concurrency = concurrency
+	}
+}
+
+// WithMaxBatchCalls limits how many calls a single batch can contain. Defaults
+// to 100.
+func WithMaxBatchCalls(maxCalls int) Option {
+	return func(c *TinyRPC) {
+		c.batch.maxCalls = maxCalls
+	}
+}
+
+// One entry in a batch request. Method is the route's name, qualified with its
+// group's namespace if it has one, e.g. "admin.users.listUsers".
+type batchCall struct {
+	Method string
+	Params json.RawMessage
+}
+
+// One entry in a batch response, which is the route's usual response along
+// with any headers the call set
+type batchResult struct {
+	Body    json.RawMessage
+	Status  Status
+	Headers http.Header `json:",omitempty"`
+}
+
+type batchTarget struct {
+	query    *RouteContainer
+	handleFn MiddlewareHandler
+}
+
+// The name a route is called by in a batch, which matches the generated
+// function
+func (r *RouteContainer) qualifiedName() string {
+	if namespace := r.Group.Namespace(); namespace != "" {
+		return namespace + "." + r.FnName
+	}
+	return r.FnName
+}
+
+// Registered by assembleHandlers, so the route and middleware chains are the
+// same ones the regular routes use
+func (c *TinyRPC) buildBatchHandler() http.HandlerFunc {
+	targets := map[string]batchTarget{}
+	for _, query := range c.handlers {
+		targets[query.qualifiedName()] = batchTarget{
+			query:    query,
+			handleFn: c.chainMiddleware(query),
+		}
+	}
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		var calls []batchCall
+		err := json.NewDecoder(limitBody(w, req, c.maxBodyBytes)).Decode(&calls)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.writeError(w, bodyTooLargeError(maxBytesErr))
+			return
+		}
+		if err != nil {
+			c.writeError(w, Errorf(STATUS_INVALID_ARGUMENT, "batch should be an array of {Method, Params}: %v", err))
+			return
+		}
+		if c.batch.maxCalls > 0 && len(calls) > c.batch.maxCalls {
+			c.writeError(w, Errorf(STATUS_INVALID_ARGUMENT, "batch has %d calls, the limit is %d", len(calls), c.batch.maxCalls))
+			return
+		}
+
+		ctx := addHeadersToContext(req.Context(), req.Header)
+		ctx = addPeerCertificateToContext(ctx, req.TLS)
+		ctx = addClientIPToContext(ctx, req.RemoteAddr)
+
+		// The client's timeout covers the whole batch, including the time
+		// calls spend waiting for their turn
+		timeout, err := clientTimeout(req.Header)
+		if err != nil {
+			c.writeError(w, err)
+			return
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeoutCause(ctx, timeout, errDeadlineExceeded)
+			defer cancel()
+		}
+
+		results := make([]batchResult, len(calls))
+		headers := make([]http.Header, len(calls))
+		limit := make(chan struct{}, c.batch.concurrency)
+		var wg sync.WaitGroup
+		for idx, call := range calls {
+			wg.Add(1)
+			limit <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-limit }()
+				headers[idx] = http.Header{}
+				results[idx] = c.runBatchCall(ctx, targets, call, headers[idx])
+			}()
+		}
+		wg.Wait()
+
+		// Each call's headers go with its own result, rather than being mixed
+		// together on the one response. The exception is cookies, which only
+		// work on the response itself, and putting them in the body would
+		// show HttpOnly ones to scripts.
+		for idx, header := range headers {
+			for _, cookie := range header.Values("Set-Cookie") {
+				w.Header().Add("Set-Cookie", cookie)
+			}
+			header.Del("Set-Cookie")
+			if len(header) > 0 {
+				results[idx].Headers = header
+			}
+		}
+
+		res, err := json.Marshal(results)
+		if err != nil {
+			c.writeError(w, Errorf(STATUS_INTERNAL, "unable to create json body: %v", err))
+			return
+		}
+		// Each call has its own status, so the batch as a whole succeeded
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+	}
+}
+
+// Run one call from a batch, returning its response in the same shape the
+// route would have sent it. Any headers the call sets are put in header.
+func (c *TinyRPC) runBatchCall(ctx context.Context, targets map[string]batchTarget, call batchCall, header http.Header) (result batchResult) {
+	fail := func(err error) batchResult {
+		res, marshalErr := buildHandlerError(err)
+		if marshalErr != nil {
+			res, _ = buildError(STATUS_INTERNAL, marshalErr.Error())
+		}
+		return toBatchResult(res)
+	}
+
+	target, ok := targets[call.Method]
+	if !ok {
+		return fail(Errorf(STATUS_NOT_FOUND, "unknown method %q", call.Method))
+	}
+	if target.query.Kind != RouteUnary {
+		return fail(Errorf(STATUS_INVALID_ARGUMENT, "%s can't be called in a batch", call.Method))
+	}
+	// The batch as a whole is limited by the app's limit, but each call also
+	// has to fit in its route's
+	if limit := c.routeBodyLimit(target.query); limit > 0 && int64(len(call.Params)) > limit {
+		return fail(bodyTooLargeError(&http.MaxBytesError{Limit: limit}))
+	}
+
+	// Calls that waited too long for their turn aren't worth starting
+	if ctx.Err() != nil {
+		return fail(cancelledError(ctx, ctx.Err()))
+	}
+	// The client's timeout is already on ctx, so only the route's is added
+	ctx, cancel, err := withDeadline(ctx, target.query, nil)
+	defer cancel()
+	if err != nil {
+		return fail(err)
+	}
+
+	ctx, state := addResponseStateToContext(ctx, header)
+	// The headers go out with the batch's response, so they're final once
+	// the call is over
+	defer state.sendHeader(func() {})
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		// Unlike in buildHandler, there's no response to abort here, so this
+		// is treated like any other panic
+		c.handlePanic(ctx, target.query, recovered, func(err error) {
+			result = fail(err)
+		})
+	}()
+
+	res, err := target.handleFn(ctx, bytes.NewReader(call.Params))
+	if err != nil {
+		return fail(fmt.Errorf("unable to execute handler: %w", err))
+	}
+	return toBatchResult(res.([]byte))
+}
+
+// Responses are built as Res[T], which has the same fields
+func toBatchResult(res []byte) batchResult {
+	var result batchResult
+	if err := json.Unmarshal(res, &result); err != nil {
+		data, _ := buildError(STATUS_INTERNAL, fmt.Sprintf("unable to read response: %v", err))
+		json.Unmarshal(data, &result)
+	}
+	return result
+}
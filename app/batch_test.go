@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatch(t *testing.T) {
+	Convey("the batch endpoint runs several calls in one request", t, func() {
+		type doubleRequest struct {
+			N int `validate:"min=1"`
+		}
+		type doubleResponse struct{ N int }
+		type explodeRequest struct{}
+		type explodeResponse struct{}
+		type watchRequest struct{}
+		type watchResponse struct{}
+
+		var running, maxRunning atomic.Int32
+		doubleFn := func(ctx context.Context, req doubleRequest) (*doubleResponse, error) {
+			now := running.Add(1)
+			defer running.Add(-1)
+			for {
+				prev := maxRunning.Load()
+				if now <= prev || maxRunning.CompareAndSwap(prev, now) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return &doubleResponse{N: req.N * 2}, nil
+		}
+		explodeFn := func(ctx context.Context, req explodeRequest) (*explodeResponse, error) {
+			panic("boom")
+		}
+		watchFn := func(ctx context.Context, req watchRequest, send func(*watchResponse) error) error {
+			return nil
+		}
+
+		var seenMethods []string
+		a := New("", "", WithBatching(2), WithMaxBatchCalls(5), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+		a.Use(func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			if GetHeader(ctx, "token") != "secret" {
+				return nil, NewError(STATUS_UNAUTHENTICATED, "bad token")
+			}
+			return handler(ctx, req)
+		})
+		NewRoute(doubleFn).Attach(a)
+		maths := a.Group("maths", func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			seenMethods = append(seenMethods, method)
+			return handler(ctx, req)
+		})
+		NewRoute(doubleFn).Attach(maths)
+		NewRoute(explodeFn).Attach(a)
+		NewRoute(doubleFn).Named("limited").WithMaxBodyBytes(20).Attach(a)
+		NewStreamRoute(watchFn).Attach(a)
+
+		call := func(body string, token string) (int, []Res[json.RawMessage]) {
+			r, _ := http.NewRequest("POST", "/tinyrpc/$batch", bytes.NewBufferString(body))
+			r.Header.Set("token", token)
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+
+			var results []Res[json.RawMessage]
+			if w.Code == http.StatusOK {
+				So(json.Unmarshal(w.Body.Bytes(), &results), ShouldBeNil)
+			}
+			return w.Code, results
+		}
+
+		Convey("with the results in the same order as the calls", func() {
+			code, results := call(`[
+				{"Method": "double", "Params": {"N": 1}},
+				{"Method": "maths.double", "Params": {"N": 2}},
+				{"Method": "double", "Params": {"N": 3}},
+				{"Method": "double", "Params": {"N": 4}}
+			]`, "secret")
+			So(code, ShouldEqual, http.StatusOK)
+			So(results, ShouldHaveLength, 4)
+			for idx, res := range results {
+				So(res.Status, ShouldEqual, STATUS_OK)
+				So(string(res.Body), ShouldEqual, fmt.Sprintf(`{"N":%d}`, (idx+1)*2))
+			}
+			So(seenMethods, ShouldResemble, []string{"double"})
+			So(maxRunning.Load(), ShouldBeLessThanOrEqualTo, 2)
+		})
+
+		Convey("with each call failing on its own", func() {
+			code, results := call(`[
+				{"Method": "double", "Params": {"N": 0}},
+				{"Method": "missing", "Params": {}},
+				{"Method": "explode", "Params": {}},
+				{"Method": "watch", "Params": {}},
+				{"Method": "double", "Params": {"N": 5}}
+			]`, "secret")
+			So(code, ShouldEqual, http.StatusOK)
+			So(results[0].Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
+			So(results[1].Status, ShouldEqual, STATUS_NOT_FOUND)
+			So(results[2].Status, ShouldEqual, STATUS_INTERNAL)
+			So(results[3].Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
+			So(results[4].Status, ShouldEqual, STATUS_OK)
+		})
+
+		Convey("with the request headers passed to every call", func() {
+			_, results := call(`[{"Method": "double", "Params": {"N": 1}}]`, "wrong")
+			So(results[0].Status, ShouldEqual, STATUS_UNAUTHENTICATED)
+		})
+
+		Convey("with each call's params limited by its route", func() {
+			code, results := call(`[
+				{"Method": "limited", "Params": {"N": 1}},
+				{"Method": "limited", "Params": {"N": 1, "Padding": "more than the route allows"}}
+			]`, "secret")
+			So(code, ShouldEqual, http.StatusOK)
+			So(results[0].Status, ShouldEqual, STATUS_OK)
+			So(results[1].Status, ShouldEqual, STATUS_RESOURCE_EXHAUSTED)
+		})
+
+		Convey("with the number of calls limited", func() {
+			code, _ := call(`[{}, {}, {}, {}, {}, {}]`, "secret")
+			So(code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("and generate auto-batching in the client", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, `if (autoBatching && options === undefined) { return queueBatchCall<doubleResponse>(params, "maths.double", headers); }`)
+			So(code, ShouldContainSubstring, "export function setAutoBatching(enabled: boolean): void {")
+			So(code, ShouldContainSubstring, "batch.calls.length >= 5")
+			So(code, ShouldContainSubstring, `call.resolve({ ...(r as Response<any>), Headers: callHeaders });`)
+		})
+	})
+
+	Convey("the batch endpoint is off by default", t, func() {
+		a := New("", "")
+		r, _ := http.NewRequest("POST", "/tinyrpc/$batch", bytes.NewBufferString("[]"))
+		w := httptest.NewRecorder()
+		a.Handler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusNotFound)
+
+		code, err := a.genCode()
+		So(err, ShouldBeNil)
+		So(code, ShouldNotContainSubstring, "autoBatching")
+	})
+}
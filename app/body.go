@@ -0,0 +1,151 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const defaultMaxBodyBytes = 4 << 20
+
+// WithMaxBodyBytes limits the size of request bodies, including batches, and
+// of the messages sent to socket routes. Requests over the limit fail with
+// STATUS_RESOURCE_EXHAUSTED and an HTTP 413, and sockets are closed.
+// Routes can set their own limit with WithMaxBodyBytes. Defaults to 4MB, zero
+// or less means no limit.
+func WithMaxBodyBytes(maxBytes int64) Option {
+	return func(c *TinyRPC) {
+		c.maxBodyBytes = maxBytes
+	}
+}
+
+// The route's own limit if it has one, otherwise the app's
+func (c *TinyRPC) routeBodyLimit(query *RouteContainer) int64 {
+	if query.MaxBodyBytes != 0 {
+		return query.MaxBodyBytes
+	}
+	return c.maxBodyBytes
+}
+
+func limitBody(w http.ResponseWriter, req *http.Request, limit int64) io.Reader {
+	if limit <= 0 {
+		return req.Body
+	}
+	return http.MaxBytesReader(w, req.Body, limit)
+}
+
+// ReadBody reads the whole request body from the req that middleware is
+// given, and returns it along with a req to pass on to the handler in its
+// place, as the body can only be read once:
+//
+//	body, req, err := app.ReadBody(req)
+//	if err != nil {
+//		return nil, err
+//	}
+//	return handler(ctx, req)
+//
+// Middleware is given the body as an io.Reader, so that routes can decode it
+// as it's read. Middleware can also pass a []byte on to the handler.
+func ReadBody(req any) ([]byte, any, error) {
+	switch body := req.(type) {
+	case []byte:
+		return body, body, nil
+	case io.Reader:
+		data, err := io.ReadAll(body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, nil, bodyTooLargeError(maxBytesErr)
+		}
+		if err != nil {
+			return nil, nil, Errorf(STATUS_INVALID_ARGUMENT, "unable to read request body: %v", err)
+		}
+		return data, bytes.NewReader(data), nil
+	case nil:
+		return nil, bytes.NewReader(nil), nil
+	default:
+		return nil, nil, Errorf(STATUS_INTERNAL, "unexpected request body of type %T", req)
+	}
+}
+
+// Decode the request straight from the body rather than reading it all in
+// first. Like json.Unmarshal, anything after the JSON value is an error.
+func decodeBody(ctx context.Context, req any, v any) error {
+	var body io.Reader
+	switch input := req.(type) {
+	case io.Reader:
+		body = input
+	case []byte:
+		body = bytes.NewReader(input)
+	default:
+		return Errorf(STATUS_INTERNAL, "unexpected request body of type %T", req)
+	}
+	dec := json.NewDecoder(body)
+	err := dec.Decode(v)
+	if err == nil {
+		_, err = dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("unexpected data after the request body")
+		}
+	} else if err == io.EOF {
+		err = errors.New("request body is empty")
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		setResponseHTTPStatus(ctx, http.StatusRequestEntityTooLarge)
+		return bodyTooLargeError(maxBytesErr)
+	}
+	return NewError(STATUS_INVALID_ARGUMENT, err.Error())
+}
+
+func bodyTooLargeError(err *http.MaxBytesError) error {
+	return &Error{
+		Status:  STATUS_RESOURCE_EXHAUSTED,
+		Message: fmt.Sprintf("request body is larger than the limit of %d bytes", err.Limit),
+		cause:   err,
+	}
+}
+
+// The HTTP status for an error written outside of the route adapter. Bodies
+// over the limit get a 413 rather than what RESOURCE_EXHAUSTED maps to, as it's
+// the request that has to change rather than how often it's sent.
+func (c *TinyRPC) errorHTTPStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return c.httpStatus(StatusFromError(err))
+}
+
+// Counts the bytes read from the request body as it's decoded
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.r == nil {
+		return 0, io.EOF
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Put a countingReader in place of the body middleware is passed. Bodies that
+// aren't readers, like an empty socket body, count as zero bytes.
+func countBody(req any) (any, *countingReader) {
+	body := &countingReader{}
+	if reader, ok := req.(io.Reader); ok {
+		body.r = reader
+		req = body
+	}
+	return req, body
+}
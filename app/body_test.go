@@ -0,0 +1,166 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBodyLimits(t *testing.T) {
+	Convey("request bodies are limited in size", t, func() {
+		type uploadRequest struct{ Data string }
+		type uploadResponse struct{ Size int }
+		type importRequest struct{ Data string }
+		type importResponse struct{ Size int }
+		type tailRequest struct{ Data string }
+		type tailResponse struct{ Size int }
+
+		uploadFn := func(ctx context.Context, req uploadRequest) (*uploadResponse, error) {
+			return &uploadResponse{Size: len(req.Data)}, nil
+		}
+		importFn := func(ctx context.Context, req importRequest) (*importResponse, error) {
+			return &importResponse{Size: len(req.Data)}, nil
+		}
+		tailFn := func(ctx context.Context, req tailRequest, send func(*tailResponse) error) error {
+			return send(&tailResponse{Size: len(req.Data)})
+		}
+
+		a := New("", "", WithMaxBodyBytes(64), WithBatching(0))
+		NewRoute(uploadFn).Attach(a)
+		NewRoute(importFn).WithMaxBodyBytes(-1).Attach(a)
+		NewStreamRoute(tailFn).WithMaxBodyBytes(256).Attach(a)
+
+		call := func(path string, body string) (*httptest.ResponseRecorder, Res[ReturnError]) {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+
+			var errRes Res[ReturnError]
+			json.Unmarshal(w.Body.Bytes(), &errRes)
+			return w, errRes
+		}
+		payload := func(size int) string {
+			return `{"Data": "` + strings.Repeat("a", size) + `"}`
+		}
+
+		Convey("with bodies under the limit decoded as normal", func() {
+			w, _ := call("/tinyrpc/upload", payload(10))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, `{"Body":{"Size":10},"Status":0}`)
+		})
+
+		Convey("with bodies over the limit rejected", func() {
+			w, errRes := call("/tinyrpc/upload", payload(100))
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(errRes.Status, ShouldEqual, STATUS_RESOURCE_EXHAUSTED)
+			So(errRes.Body.ErrorMessage, ShouldEqual, "request body is larger than the limit of 64 bytes")
+		})
+
+		Convey("with the route's own limit used instead", func() {
+			w, _ := call("/tinyrpc/import", payload(10000))
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			w, _ = call("/tinyrpc/tail", payload(100))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `{"Size":100}`)
+
+			w, errRes := call("/tinyrpc/tail", payload(300))
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(errRes.Body.ErrorMessage, ShouldEqual, "request body is larger than the limit of 256 bytes")
+		})
+
+		Convey("with batches held to the app's limit", func() {
+			w, errRes := call("/tinyrpc/$batch", `[{"Method": "upload", "Params": `+payload(100)+`}]`)
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(errRes.Status, ShouldEqual, STATUS_RESOURCE_EXHAUSTED)
+		})
+
+		Convey("with empty bodies and trailing data invalid", func() {
+			w, errRes := call("/tinyrpc/upload", "")
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(errRes.Body.ErrorMessage, ShouldEqual, "request body is empty")
+
+			w, errRes = call("/tinyrpc/upload", `{"Data": "a"} {}`)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(errRes.Body.ErrorMessage, ShouldEqual, "unexpected data after the request body")
+
+			w, _ = call("/tinyrpc/upload", "{\"Data\": \"a\"}\n")
+			So(w.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("with the client treating a 413 as resource exhausted", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "case 413: return Status.STATUS_RESOURCE_EXHAUSTED;")
+		})
+	})
+}
+
+func TestReadBody(t *testing.T) {
+	Convey("middleware can read the body and pass it on", t, func() {
+		type echoRequest struct{ Message string }
+		type echoResponse struct{ Message string }
+		echoFn := func(ctx context.Context, req echoRequest) (*echoResponse, error) {
+			return &echoResponse{Message: req.Message}, nil
+		}
+
+		var seen []byte
+		a := New("", "", WithMaxBodyBytes(64))
+		NewRoute(echoFn).AttachWithMiddleware(a, func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			body, req, err := ReadBody(req)
+			if err != nil {
+				return nil, err
+			}
+			seen = body
+			return handler(ctx, req)
+		})
+
+		call := func(body string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", "/tinyrpc/echo", bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("with the route still able to decode it", func() {
+			w := call(`{"Message": "hi"}`)
+			So(string(seen), ShouldEqual, `{"Message": "hi"}`)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, `"Message":"hi"`)
+		})
+
+		Convey("with bodies over the limit still rejected", func() {
+			w := call(`{"Message": "` + strings.Repeat("a", 100) + `"}`)
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+	})
+
+	Convey("middleware can pass a []byte on to the handler", t, func() {
+		type echoRequest struct{ Message string }
+		type echoResponse struct{ Message string }
+		echoFn := func(ctx context.Context, req echoRequest) (*echoResponse, error) {
+			return &echoResponse{Message: req.Message}, nil
+		}
+
+		a := New("", "")
+		NewRoute(echoFn).AttachWithMiddleware(a, func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			return handler(ctx, []byte(`{"Message": "replaced"}`))
+		})
+
+		r, _ := http.NewRequest("POST", "/tinyrpc/echo", bytes.NewBufferString(`{"Message": "hi"}`))
+		w := httptest.NewRecorder()
+		a.Handler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldContainSubstring, `"Message":"replaced"`)
+
+		_, passed, err := ReadBody([]byte("raw"))
+		So(err, ShouldBeNil)
+		So(passed, ShouldResemble, []byte("raw"))
+	})
+}
@@ -3,11 +3,37 @@ package app
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/concolorcarne/tinyrpc/typescriptify"
 )
 
-func buildConvertHeaderFunction(headerParamSignature string) typescriptify.TypeScriptFunction {
+// Header structs are converted to plain objects, with slices joined the same
+// way the server splits them. If some routes don't have a header type, their
+// headers are passed through as they are.
+func buildConvertHeaderFunction(headerParamSignature string, acceptsHeadersInit bool) typescriptify.TypeScriptFunction {
+	body := []string{
+		`let r: Record<string, string> = {};`,
+		`if (headers === undefined) { return r; }`,
+	}
+	if acceptsHeadersInit {
+		body = append(body,
+			`if (headers instanceof Headers || Array.isArray(headers)) {`,
+			`	new Headers(headers).forEach((value, key) => { r[key] = value; });`,
+			`	return r;`,
+			`}`,
+		)
+	}
+	body = append(body,
+		``,
+		`Object.entries(headers).forEach(([key, v]) => {`,
+		`	if (v !== undefined) { r[key] = Array.isArray(v) ? v.join(", ") : String(v); }`,
+		`})`,
+		`return r`,
+	)
+
 	return typescriptify.TypeScriptFunction{
 		IsAsync: false,
 		Name:    "convertHeaders",
@@ -15,25 +41,105 @@ func buildConvertHeaderFunction(headerParamSignature string) typescriptify.TypeS
 			{Name: "headers?", Type: headerParamSignature},
 		},
 		ReturnType: "Record<string, string>",
-		Body: []string{
-			`let r: Record<string, string> = {};`,
-			`if (headers === undefined) { return r; }`,
-			``,
-			`Object.keys(headers).forEach((key) => {`,
-			fmt.Sprintf(`	const v = headers[key as keyof %s]`, headerParamSignature),
-			`	if( v !== undefined) { r[key] = v; }`,
-			`})`,
-			`return r`,
-		},
+		Body:       body,
 	}
 }
 
-func buildGenFunc(headerParamSignature string, host string, shouldConvertHeaders bool) typescriptify.TypeScriptFunction {
-	headerConversion := "headers"
-	if shouldConvertHeaders {
-		headerConversion = "convertHeaders(headers)"
+// The parts of the app's config that the generated request functions need
+type clientConfig struct {
+	// The header types used by any of the routes, and whether there are
+	// routes without one
+	headerTypes          []reflect.Type
+	untypedHeaders       bool
+	headerParamSignature string
+	headerConversion     string
+	baseURL              string
+	socketBaseURL        string
+	// Whether the client can send a traceparent header
+	tracing bool
+	// Whether to send cookies to the app when it's on another origin
+	credentials bool
+}
+
+// The options every request starts with
+func (cfg clientConfig) requestInit(signal string) string {
+	fields := []string{`method: "POST"`}
+	if signal != "" {
+		fields = append(fields, "signal: "+signal)
+	}
+	if cfg.credentials {
+		fields = append(fields, `credentials: "include"`)
 	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
 
+func (c *TinyRPC) clientConfig() clientConfig {
+	cfg := clientConfig{
+		headerParamSignature: "HeadersInit",
+		headerConversion:     "headers",
+		baseURL:              "http://" + c.host,
+		socketBaseURL:        "ws://" + c.host,
+		tracing:              c.tracing != nil,
+		credentials:          c.cors != nil && c.cors.config.AllowCredentials,
+	}
+	cfg.headerTypes, cfg.untypedHeaders = c.routeHeaderTypes()
+	if len(cfg.headerTypes) > 0 {
+		// Add the convertHeaders(headers) option if we're using a custom
+		// header type. The shared functions take any of the routes' types.
+		signatures := []string{}
+		for _, headerType := range cfg.headerTypes {
+			signatures = append(signatures, typescriptify.TypeName(headerType))
+		}
+		if cfg.untypedHeaders {
+			signatures = append(signatures, "HeadersInit")
+		}
+		cfg.headerParamSignature = strings.Join(signatures, " | ")
+		cfg.headerConversion = "convertHeaders(headers)"
+	}
+	if c.tls.enabled() {
+		cfg.baseURL = "https://" + c.host
+		cfg.socketBaseURL = "wss://" + c.host
+	}
+	return cfg
+}
+
+// The distinct header types of the routes, in the order they're first used
+func (c *TinyRPC) routeHeaderTypes() ([]reflect.Type, bool) {
+	headerTypes := []reflect.Type{}
+	seen := map[reflect.Type]bool{}
+	untyped := false
+	add := func(headerType reflect.Type) {
+		if headerType != nil && !seen[headerType] {
+			seen[headerType] = true
+			headerTypes = append(headerTypes, headerType)
+		}
+	}
+
+	add(c.headerType)
+	for _, qr := range c.handlers {
+		headerType := c.routeHeaderType(qr)
+		if headerType == nil {
+			untyped = true
+		}
+		add(headerType)
+	}
+	return headerTypes, untyped
+}
+
+// The headers parameter of a route's generated function, which is required if
+// the route's header type has any required fields
+func (c *TinyRPC) routeHeaderParam(qr *RouteContainer) typescriptify.FunctionParameter {
+	headerType := c.routeHeaderType(qr)
+	if headerType == nil {
+		return typescriptify.FunctionParameter{Name: "headers?", Type: "HeadersInit"}
+	}
+	if hasRequiredHeaders(headerType) {
+		return typescriptify.FunctionParameter{Name: "headers", Type: typescriptify.TypeName(headerType)}
+	}
+	return typescriptify.FunctionParameter{Name: "headers?", Type: typescriptify.TypeName(headerType)}
+}
+
+func buildGenFunc(cfg clientConfig) typescriptify.TypeScriptFunction {
 	return typescriptify.TypeScriptFunction{
 		IsAsync:    true,
 		DontExport: true,
@@ -41,32 +147,37 @@ func buildGenFunc(headerParamSignature string, host string, shouldConvertHeaders
 		Parameters: []typescriptify.FunctionParameter{
 			{Name: "params", Type: "T"},
 			{Name: "path", Type: "string"},
-			{Name: "headers?", Type: headerParamSignature},
+			{Name: "headers?", Type: cfg.headerParamSignature},
+			{Name: "options?", Type: "CallOptions"},
 		},
 		ReturnType: "Promise<Error | Response<K>>",
 		Body: []string{
-			`const requestOptions: RequestInit = { method: "POST" };`,
+			`const signal = callSignal(options);`,
+			fmt.Sprintf(`const requestOptions: RequestInit = %s;`, cfg.requestInit("signal")),
 			`requestOptions.body = JSON.stringify(params as T);`,
-			// Add the convertHeaders(headers) option if we're using a custom
-			// header type
-			fmt.Sprintf(`requestOptions.headers = %s;`, headerConversion),
+			`requestOptions.headers = requestHeaders(headers, options);`,
 
 			``,
-			fmt.Sprintf(`const host = "http://%s";`, host),
+			fmt.Sprintf(`const host = "%s";`, cfg.baseURL),
 			`const url = host + path;`,
 			// Generate the code to handle fetch function errors
 			`let res;`,
 			`try { res = await fetch(url, requestOptions); }`,
 			`catch (e) {`,
+			`	if (timedOut(signal, options)) { return { Message: "Deadline exceeded", Status: Status.STATUS_DEADLINE_EXCEEDED, IsError: true } as Error; }`,
+			`	if (signal?.aborted) { return { Message: "Request cancelled", Status: Status.STATUS_CANCELLED, IsError: true } as Error; }`,
 			`	return { Message: "Likely network error: " + e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error;`,
 			`}`,
 
-			// Generate the code to handle non-JSON response errors
+			// Generate the code to handle non-JSON response errors, e.g. from a
+			// proxy in front of the server
 			`let body;`,
 			`try { body = await res.json(); }`,
 			`catch (e) {`,
-			`	// couldn't cast to JSON`,
-			`	return { Message: e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error;`,
+			`	if (timedOut(signal, options)) { return { Message: "Deadline exceeded", Status: Status.STATUS_DEADLINE_EXCEEDED, IsError: true } as Error; }`,
+			`	// couldn't cast to JSON, so the HTTP status is all we've got`,
+			`	const status = res.ok ? Status.STATUS_UNAVAILABLE : statusFromHTTPCode(res.status);`,
+			`	return { Message: "Unable to parse response (HTTP " + res.status + "): " + e, Status: status, RetryAfter: retryAfter(res.headers), IsError: true } as Error;`,
 			`}`,
 
 			// Generate the code to handle the application returning an error
@@ -74,15 +185,20 @@ func buildGenFunc(headerParamSignature string, host string, shouldConvertHeaders
 			`let innerBody = body["Body"];`,
 			`if (innerBody !== undefined && innerBody["ErrorMessage"] !== undefined) {`,
 			`	try {`,
-			`		let r = body as Response<ErrorRes>;`,
-			`		return { Message: r.Body.ErrorMessage, Status: r.Status, IsError: true } as Error;`,
+			`		const err = toError(body as Response<ErrorRes>);`,
+			`		err.Headers = res.headers;`,
+			`		return err;`,
 			`	} catch (e) {`,
 			`		return { Message: e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error`,
 			`	}`,
 			`}`,
+			`if (!res.ok) {`,
+			`	return { Message: "Unexpected response: HTTP " + res.status + " " + res.statusText, Status: statusFromHTTPCode(res.status), RetryAfter: retryAfter(res.headers), Headers: res.headers, IsError: true } as Error;`,
+			`}`,
 
 			`try {`,
 			`	let r = body as Response<K>;`,
+			`	r.Headers = res.headers;`,
 			`	return r;`,
 			`} catch (e) {`,
 			`	return { Message: e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error`,
@@ -91,46 +207,655 @@ func buildGenFunc(headerParamSignature string, host string, shouldConvertHeaders
 	}
 }
 
+// The request's headers, with the timeout from the options in the same format
+// as gRPC's grpc-timeout. It only has room for 8 digits, so anything longer
+// is sent in seconds.
+func buildRequestHeadersFunc(cfg clientConfig) typescriptify.TypeScriptFunction {
+	body := []string{
+		fmt.Sprintf(`const r = new Headers(%s);`, cfg.headerConversion),
+		`if (options?.timeout !== undefined) {`,
+		`	const ms = Math.max(1, Math.ceil(options.timeout));`,
+		fmt.Sprintf(`	r.set("%s", ms < 1e8 ? ms + "m" : Math.ceil(ms / 1000) + "S");`, timeoutHeader),
+		`}`,
+	}
+	if cfg.tracing {
+		// An explicit traceparent is used as is, e.g. from the page's own
+		// tracing, otherwise each call starts a new trace if tracing is on
+		body = append(body,
+			`const traceparent = options?.traceparent ?? (tracing ? newTraceparent() : undefined);`,
+			`if (traceparent !== undefined) {`,
+			fmt.Sprintf(`	r.set("%s", traceparent);`, traceparentHeader),
+			`}`,
+		)
+	}
+	body = append(body, `return r;`)
+
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "requestHeaders",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "headers?", Type: cfg.headerParamSignature},
+			{Name: "options?", Type: "CallOptions"},
+		},
+		ReturnType: "Headers",
+		Body:       body,
+	}
+}
+
+func buildSetTracingFunc() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		Name: "setTracing",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "enabled", Type: "boolean"},
+		},
+		ReturnType: "void",
+		Body: []string{
+			`tracing = enabled;`,
+		},
+	}
+}
+
+// A random, sampled traceparent for a new trace
+func buildNewTraceparentFunc() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "newTraceparent",
+		ReturnType: "string",
+		Body: []string{
+			`const bytes = new Uint8Array(24);`,
+			`crypto.getRandomValues(bytes);`,
+			`const hex = Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");`,
+			`return "00-" + hex.slice(0, 32) + "-" + hex.slice(32) + "-01";`,
+		},
+	}
+}
+
+// Combines the caller's signal with the timeout, so that either aborts the
+// request
+func buildCallSignalFunc() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "callSignal",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "options?", Type: "CallOptions"},
+		},
+		ReturnType: "AbortSignal | undefined",
+		Body: []string{
+			`if (options?.timeout === undefined) { return options?.signal; }`,
+			`const controller = new AbortController();`,
+			`const timer = setTimeout(() => controller.abort(), options.timeout);`,
+			`const signal = options.signal;`,
+			`if (signal !== undefined) {`,
+			`	const abort = () => { clearTimeout(timer); controller.abort(); };`,
+			`	if (signal.aborted) { abort(); }`,
+			`	signal.addEventListener("abort", abort, { once: true });`,
+			`}`,
+			`return controller.signal;`,
+		},
+	}
+}
+
+// Whether the request was aborted because of its timeout, rather than by the
+// caller
+func buildTimedOutFunc() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "timedOut",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "signal", Type: "AbortSignal | undefined"},
+			{Name: "options?", Type: "CallOptions"},
+		},
+		ReturnType: "boolean",
+		Body: []string{
+			`return signal?.aborted === true && options?.timeout !== undefined && options.signal?.aborted !== true;`,
+		},
+	}
+}
+
+// The Retry-After header in milliseconds, for errors from something other
+// than the server, e.g. a proxy that's rate limiting
+func buildRetryAfterFunc() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "retryAfter",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "headers", Type: "Headers"},
+		},
+		ReturnType: "number | undefined",
+		Body: []string{
+			`const value = headers.get("Retry-After");`,
+			`if (value === null) { return undefined; }`,
+			`const seconds = Number(value);`,
+			`if (!isNaN(seconds)) { return seconds * 1000; }`,
+			`const date = Date.parse(value);`,
+			`return isNaN(date) ? undefined : Math.max(0, date - Date.now());`,
+		},
+	}
+}
+
+func buildToErrorFunction() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "toError",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "r", Type: "Response<ErrorRes>"},
+		},
+		ReturnType: "Error",
+		Body: []string{
+			`return { Message: r.Body.ErrorMessage, Status: r.Status, Details: r.Body.Details, Violations: r.Body.Violations, RetryAfter: r.Body.RetryAfter, IsError: true } as Error;`,
+		},
+	}
+}
+
+// Streams are read with fetch rather than EventSource, as EventSource can't
+// POST a body or send custom headers. Errors are thrown, as there's no return
+// value to put them in.
+func buildStreamFunc(cfg clientConfig) typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		IsAsync:     true,
+		IsGenerator: true,
+		DontExport:  true,
+		Name:        "streamFunc<T, K>",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "params", Type: "T"},
+			{Name: "path", Type: "string"},
+			{Name: "headers?", Type: cfg.headerParamSignature},
+			{Name: "options?", Type: "CallOptions"},
+		},
+		ReturnType: "AsyncGenerator<K, void, undefined>",
+		Body: []string{
+			// Our own controller, so the request is also cancelled when the
+			// caller breaks out of the loop early
+			`const controller = new AbortController();`,
+			`const signal = callSignal(options);`,
+			`if (signal !== undefined) {`,
+			`	if (signal.aborted) { controller.abort(); }`,
+			`	signal.addEventListener("abort", () => controller.abort(), { once: true });`,
+			`}`,
+			fmt.Sprintf(`const requestOptions: RequestInit = %s;`, cfg.requestInit("controller.signal")),
+			`requestOptions.body = JSON.stringify(params as T);`,
+			`requestOptions.headers = requestHeaders(headers, options);`,
+			``,
+			fmt.Sprintf(`const host = "%s";`, cfg.baseURL),
+			`const url = host + path;`,
+			`let res;`,
+			`try { res = await fetch(url, requestOptions); }`,
+			`catch (e) {`,
+			`	if (timedOut(signal, options)) { throw { Message: "Deadline exceeded", Status: Status.STATUS_DEADLINE_EXCEEDED, IsError: true } as Error; }`,
+			`	if (controller.signal.aborted) { return; }`,
+			`	throw { Message: "Likely network error: " + e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error;`,
+			`}`,
+
+			// Anything that isn't an event stream is an error from before the
+			// stream started, so it's a regular response
+			`if (!(res.headers.get("Content-Type") ?? "").startsWith("text/event-stream") || res.body === null) {`,
+			`	let body;`,
+			`	try { body = await res.json(); }`,
+			`	catch (e) {`,
+			`		throw { Message: "Unable to parse response (HTTP " + res.status + "): " + e, Status: statusFromHTTPCode(res.status), IsError: true } as Error;`,
+			`	}`,
+			`	if (body["Body"] !== undefined && body["Body"]["ErrorMessage"] !== undefined) { throw toError(body as Response<ErrorRes>); }`,
+			`	throw { Message: "Unexpected response: HTTP " + res.status + " " + res.statusText, Status: statusFromHTTPCode(res.status), IsError: true } as Error;`,
+			`}`,
+			``,
+			`const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();`,
+			`let buffer = "";`,
+			`try {`,
+			`	while (true) {`,
+			`		let chunk;`,
+			`		try { chunk = await reader.read(); }`,
+			`		catch (e) {`,
+			`			if (timedOut(signal, options)) { throw { Message: "Deadline exceeded", Status: Status.STATUS_DEADLINE_EXCEEDED, IsError: true } as Error; }`,
+			`			if (controller.signal.aborted) { return; }`,
+			`			throw { Message: "Stream interrupted: " + e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error;`,
+			`		}`,
+			`		if (chunk.done) { break; }`,
+			`		buffer += chunk.value;`,
+			``,
+			`		// Events are separated by a blank line`,
+			`		let boundary;`,
+			`		while ((boundary = buffer.indexOf("\n\n")) !== -1) {`,
+			`			const event = parseStreamEvent(buffer.slice(0, boundary));`,
+			`			buffer = buffer.slice(boundary + 2);`,
+			`			if (event.name === "end") { return; }`,
+			`			if (event.name === "error") { throw toError(JSON.parse(event.data) as Response<ErrorRes>); }`,
+			`			yield (JSON.parse(event.data) as Response<K>).Body;`,
+			`		}`,
+			`	}`,
+			`	throw { Message: "Stream closed before it ended", Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error;`,
+			`} finally {`,
+			`	controller.abort();`,
+			`}`,
+		},
+	}
+}
+
+func buildParseStreamEventFunction() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "parseStreamEvent",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "raw", Type: "string"},
+		},
+		ReturnType: "{ name: string; data: string }",
+		Body: []string{
+			`let name = "message";`,
+			`let data = "";`,
+			`for (const line of raw.split("\n")) {`,
+			`	if (line.startsWith("event: ")) { name = line.slice(7); }`,
+			`	else if (line.startsWith("data: ")) { data += line.slice(6); }`,
+			`}`,
+			`return { name, data };`,
+		},
+	}
+}
+
+// Auto-batching is off until the client turns it on, as it changes when
+// requests are sent
+func buildSetAutoBatchingFunc() typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		Name: "setAutoBatching",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "enabled", Type: "boolean"},
+		},
+		ReturnType: "void",
+		Body: []string{
+			`autoBatching = enabled;`,
+		},
+	}
+}
+
+// Only calls with the same headers can share a batch
+func buildBatchKeyFunc(cfg clientConfig) typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "batchKey",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "headers?", Type: cfg.headerParamSignature},
+		},
+		ReturnType: "string",
+		Body: []string{
+			`const entries: string[][] = [];`,
+			fmt.Sprintf(`new Headers(%s).forEach((value, key) => entries.push([key, value]));`, cfg.headerConversion),
+			`return JSON.stringify(entries);`,
+		},
+	}
+}
+
+// Calls made in the same tick are queued up and sent together once it ends,
+// in batches of at most maxCalls
+func buildQueueBatchCallFunc(cfg clientConfig, maxCalls int) typescriptify.TypeScriptFunction {
+	body := []string{
+		`return new Promise((resolve) => {`,
+		`	const key = batchKey(headers);`,
+		`	let batch = pendingBatches.get(key);`,
+	}
+	if maxCalls > 0 {
+		body = append(body, fmt.Sprintf(`	if (batch === undefined || batch.calls.length >= %d) {`, maxCalls))
+	} else {
+		body = append(body, `	if (batch === undefined) {`)
+	}
+	body = append(body,
+		`		const queued: PendingBatch = { headers: headers, calls: [] };`,
+		`		pendingBatches.set(key, queued);`,
+		`		setTimeout(() => {`,
+		`			if (pendingBatches.get(key) === queued) { pendingBatches.delete(key); }`,
+		`			sendBatch(queued.calls, queued.headers);`,
+		`		}, 0);`,
+		`		batch = queued;`,
+		`	}`,
+		`	batch.calls.push({ Method: method, Params: params, resolve: resolve as (res: Error | Response<any>) => void });`,
+		`});`,
+	)
+
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "queueBatchCall<K>",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "params", Type: "any"},
+			{Name: "method", Type: "string"},
+			{Name: "headers?", Type: cfg.headerParamSignature},
+		},
+		ReturnType: "Promise<Error | Response<K>>",
+		Body:       body,
+	}
+}
+
+// Errors that stop the whole batch are passed on to every call in it. Each
+// call's Headers are the batch response's, along with the ones the call set.
+func buildSendBatchFunc(cfg clientConfig, path string) typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		IsAsync:    true,
+		DontExport: true,
+		Name:       "sendBatch",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "calls", Type: "PendingBatchCall[]"},
+			{Name: "headers?", Type: cfg.headerParamSignature},
+		},
+		ReturnType: "Promise<void>",
+		Body: []string{
+			`const failAll = (err: Error) => calls.forEach((call) => call.resolve(err));`,
+			fmt.Sprintf(`const requestOptions: RequestInit = %s;`, cfg.requestInit("")),
+			`requestOptions.body = JSON.stringify(calls.map((call) => ({ Method: call.Method, Params: call.Params })));`,
+			`requestOptions.headers = requestHeaders(headers);`,
+			``,
+			fmt.Sprintf(`const host = "%s";`, cfg.baseURL),
+			`let res;`,
+			fmt.Sprintf(`try { res = await fetch(host + "%s", requestOptions); }`, path),
+			`catch (e) {`,
+			`	return failAll({ Message: "Likely network error: " + e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error);`,
+			`}`,
+			`let body;`,
+			`try { body = await res.json(); }`,
+			`catch (e) {`,
+			`	return failAll({ Message: "Unable to parse response (HTTP " + res.status + "): " + e, Status: statusFromHTTPCode(res.status), IsError: true } as Error);`,
+			`}`,
+			`if (!Array.isArray(body)) {`,
+			`	if (body["Body"] !== undefined && body["Body"]["ErrorMessage"] !== undefined) { return failAll(toError(body as Response<ErrorRes>)); }`,
+			`	return failAll({ Message: "Unexpected response: HTTP " + res.status + " " + res.statusText, Status: statusFromHTTPCode(res.status), IsError: true } as Error);`,
+			`}`,
+			``,
+			`calls.forEach((call, idx) => {`,
+			`	const r = body[idx];`,
+			`	if (r === undefined) {`,
+			`		return call.resolve({ Message: "Missing response for " + call.Method, Status: Status.STATUS_UNKNOWN, IsError: true } as Error);`,
+			`	}`,
+			`	// Each call's headers come with its result, on top of the batch's`,
+			`	const callHeaders = new Headers(res.headers);`,
+			`	for (const key in r["Headers"]) { r["Headers"][key].forEach((value: string) => callHeaders.append(key, value)); }`,
+			`	if (r["Body"] !== undefined && r["Body"]["ErrorMessage"] !== undefined) {`,
+			`		call.resolve({ ...toError(r as Response<ErrorRes>), Headers: callHeaders });`,
+			`	} else {`,
+			`		call.resolve({ ...(r as Response<any>), Headers: callHeaders });`,
+			`	}`,
+			`});`,
+		},
+	}
+}
+
+func buildBatchState(cfg clientConfig) string {
+	return fmt.Sprintf(`
+interface PendingBatchCall { Method: string; Params: any; resolve: (res: Error | Response<any>) => void; }
+interface PendingBatch { headers?: %s; calls: PendingBatchCall[]; }
+let autoBatching = false;
+const pendingBatches = new Map<string, PendingBatch>();
+`, cfg.headerParamSignature)
+}
+
+// Browsers can't set headers when opening a WebSocket, so they're sent as
+// query parameters instead
+func buildSocketURLFunc(cfg clientConfig) typescriptify.TypeScriptFunction {
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "socketURL",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "path", Type: "string"},
+			{Name: "headers?", Type: cfg.headerParamSignature},
+		},
+		ReturnType: "string",
+		Body: []string{
+			fmt.Sprintf(`const host = "%s";`, cfg.socketBaseURL),
+			`const query = new URLSearchParams();`,
+			`requestHeaders(headers).forEach((value, key) => query.append(key, value));`,
+			`const search = query.toString();`,
+			`return host + path + (search === "" ? "" : "?" + search);`,
+		},
+	}
+}
+
+// The client's end of a socket route. Messages sent before the connection is
+// open are queued, and if the connection drops it's reopened with exponential
+// backoff. Close codes of 4000 and up carry the Status of the error that ended
+// the connection, and only STATUS_UNAVAILABLE is worth reconnecting after.
+const socketConnectionClass = `
+export class SocketConnection<Send, Receive> {
+	private socket: WebSocket | undefined;
+	private queue: string[] = [];
+	private messageHandlers = new Set<(message: Receive) => void>();
+	private errorHandlers = new Set<(error: Error) => void>();
+	private closed = false;
+	private attempts = 0;
+	private retryTimer: ReturnType<typeof setTimeout> | undefined;
+
+	constructor(private url: string, private shouldReconnect: boolean = true) {
+		this.connect();
+	}
+
+	send(message: Send): void {
+		const data = JSON.stringify(message);
+		if (this.socket !== undefined && this.socket.readyState === WebSocket.OPEN) {
+			this.socket.send(data);
+			return;
+		}
+		this.queue.push(data);
+	}
+
+	// Returns a function that removes the handler
+	onMessage(handler: (message: Receive) => void): () => void {
+		this.messageHandlers.add(handler);
+		return () => { this.messageHandlers.delete(handler); };
+	}
+
+	// Returns a function that removes the handler
+	onError(handler: (error: Error) => void): () => void {
+		this.errorHandlers.add(handler);
+		return () => { this.errorHandlers.delete(handler); };
+	}
+
+	reconnect(): void {
+		this.closed = false;
+		this.attempts = 0;
+		clearTimeout(this.retryTimer);
+		const socket = this.socket;
+		this.socket = undefined;
+		socket?.close(1000);
+		this.connect();
+	}
+
+	close(): void {
+		this.closed = true;
+		this.queue = [];
+		clearTimeout(this.retryTimer);
+		const socket = this.socket;
+		this.socket = undefined;
+		socket?.close(1000);
+	}
+
+	private connect(): void {
+		const socket = new WebSocket(this.url);
+		this.socket = socket;
+
+		socket.onopen = () => {
+			this.attempts = 0;
+			const queued = this.queue;
+			this.queue = [];
+			queued.forEach((data) => socket.send(data));
+		};
+		socket.onmessage = (event) => {
+			let body;
+			try { body = JSON.parse(event.data); }
+			catch (e) {
+				this.emitError({ Message: "Unable to parse message: " + e, Status: Status.STATUS_UNKNOWN, IsError: true } as Error);
+				return;
+			}
+			if (body["Body"] !== undefined && body["Body"]["ErrorMessage"] !== undefined) {
+				this.emitError(toError(body as Response<ErrorRes>));
+				return;
+			}
+			this.messageHandlers.forEach((handler) => handler((body as Response<Receive>).Body));
+		};
+		socket.onclose = (event) => {
+			// Ignore sockets that have been replaced by reconnect
+			if (this.socket !== socket) { return; }
+			this.socket = undefined;
+			if (event.code === 1006) {
+				this.emitError({ Message: "Connection lost", Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error);
+			}
+			const retry = event.code !== 1000 && (event.code < 4000 || event.code === 4000 + Status.STATUS_UNAVAILABLE);
+			if (this.closed || !this.shouldReconnect || !retry) { return; }
+
+			const delay = Math.min(30000, 500 * 2 ** this.attempts);
+			this.attempts++;
+			this.retryTimer = setTimeout(() => this.connect(), delay);
+		};
+	}
+
+	private emitError(error: Error): void {
+		this.errorHandlers.forEach((handler) => handler(error));
+	}
+}
+`
+
+// Build the reverse of the app's Status -> HTTP code mapping, for responses
+// that don't carry a tinyrpc body. Where several statuses share a code, the
+// first in AllStatus wins.
+func buildStatusFromHTTPCodeFunction(httpStatus func(Status) int) typescriptify.TypeScriptFunction {
+	body := []string{`switch (code) {`}
+	seen := map[int]bool{}
+	for _, status := range AllStatus {
+		code := httpStatus(status)
+		if status == STATUS_OK || seen[code] {
+			continue
+		}
+		seen[code] = true
+		body = append(body, fmt.Sprintf(`	case %d: return Status.%s;`, code, status.TSName()))
+	}
+	if !seen[http.StatusRequestEntityTooLarge] {
+		// Sent for bodies over the size limit
+		body = append(body, fmt.Sprintf(`	case %d: return Status.%s;`, http.StatusRequestEntityTooLarge, STATUS_RESOURCE_EXHAUSTED.TSName()))
+	}
+	body = append(body,
+		`	default: return Status.STATUS_UNKNOWN;`,
+		`}`,
+	)
+
+	return typescriptify.TypeScriptFunction{
+		DontExport: true,
+		Name:       "statusFromHTTPCode",
+		Parameters: []typescriptify.FunctionParameter{
+			{Name: "code", Type: "number"},
+		},
+		ReturnType: "Status",
+		Body:       body,
+	}
+}
+
 func (c *TinyRPC) genCode() (string, error) {
 	converter := typescriptify.New()
 	converter.DontExport = false
 	converter.BackupDir = ""
 	converter.CreateInterface = true
-	converter.Quiet = true
+	converter.Logger = c.logger()
 
-	headerParamSignature := "HeadersInit"
+	cfg := c.clientConfig()
 
-	if c.headerType != nil {
-		// We have a header type, lets add that type and reference it below
-		converter.AddType(c.headerType)
-		headerParamSignature = c.headerType.Name()
-		converter.AddFunction(buildConvertHeaderFunction(headerParamSignature))
+	if len(cfg.headerTypes) > 0 {
+		// We have header types, lets add them and reference them below
+		for _, headerType := range cfg.headerTypes {
+			converter.AddType(headerType)
+		}
+		converter.AddFunction(buildConvertHeaderFunction(cfg.headerParamSignature, cfg.untypedHeaders))
 	}
 
+	hasStreams := false
+	hasSockets := false
 	for _, qr := range c.handlers {
 		converter.AddType(qr.InputType)
 		converter.AddType(qr.OutputType)
+
+		if qr.Kind == RouteSocket {
+			hasSockets = true
+			converter.AddFunction(typescriptify.TypeScriptFunction{
+				Name:      qr.FnName,
+				Namespace: qr.Group.Namespace(),
+				Parameters: []typescriptify.FunctionParameter{
+					c.routeHeaderParam(qr),
+					{Name: "reconnect?", Type: "boolean"},
+				},
+				ReturnType: fmt.Sprintf("SocketConnection<%s, %s>", typescriptify.TypeName(qr.InputType), typescriptify.TypeName(qr.OutputType)),
+				Body: []string{fmt.Sprintf(
+					`return new SocketConnection<%s, %s>(socketURL("%s", headers), reconnect);`,
+					typescriptify.TypeName(qr.InputType),
+					typescriptify.TypeName(qr.OutputType),
+					c.pathPrefix+qr.QueryPath,
+				)},
+			})
+			continue
+		}
+
+		if qr.Kind == RouteStream {
+			hasStreams = true
+			converter.AddFunction(typescriptify.TypeScriptFunction{
+				Name:      qr.FnName,
+				Namespace: qr.Group.Namespace(),
+				Parameters: []typescriptify.FunctionParameter{
+					{Name: "params", Type: typescriptify.TypeName(qr.InputType)},
+					c.routeHeaderParam(qr),
+					{Name: "options?", Type: "CallOptions"},
+				},
+				ReturnType: fmt.Sprintf("AsyncGenerator<%s, void, undefined>", typescriptify.TypeName(qr.OutputType)),
+				Body: []string{fmt.Sprintf(
+					`return streamFunc<%s, %s>(params, "%s", headers, options);`,
+					typescriptify.TypeName(qr.InputType),
+					typescriptify.TypeName(qr.OutputType),
+					c.pathPrefix+qr.QueryPath,
+				)},
+			})
+			continue
+		}
+
+		body := []string{fmt.Sprintf(
+			`return genFunc<%s, %s>(params, "%s", headers, options);`,
+			typescriptify.TypeName(qr.InputType),
+			typescriptify.TypeName(qr.OutputType),
+			c.pathPrefix+qr.QueryPath,
+		)}
+		if c.batch.enabled {
+			body = append([]string{fmt.Sprintf(
+				`if (autoBatching && options === undefined) { return queueBatchCall<%s>(params, "%s", headers); }`,
+				typescriptify.TypeName(qr.OutputType),
+				qr.qualifiedName(),
+			)}, body...)
+		}
 		converter.AddFunction(typescriptify.TypeScriptFunction{
-			IsAsync: true,
-			Name:    qr.FnName,
+			IsAsync:   true,
+			Name:      qr.FnName,
+			Namespace: qr.Group.Namespace(),
 			Parameters: []typescriptify.FunctionParameter{
-				{Name: "params", Type: qr.InputType.Name()},
-				{Name: "headers?", Type: headerParamSignature},
+				{Name: "params", Type: typescriptify.TypeName(qr.InputType)},
+				c.routeHeaderParam(qr),
+				{Name: "options?", Type: "CallOptions"},
 			},
-			ReturnType: fmt.Sprintf("Promise<Response<%s> | Error>", qr.OutputType.Name()),
-			Body: []string{fmt.Sprintf(
-				`return genFunc<%s, %s>(params, "%s", headers);`,
-				qr.InputType.Name(),
-				qr.OutputType.Name(),
-				qr.QueryPath,
-			)},
+			ReturnType: fmt.Sprintf("Promise<Response<%s> | Error>", typescriptify.TypeName(qr.OutputType)),
+			Body:       body,
 		})
 	}
 
 	// Generate the 'base' function, then generate the additional functions
-	converter.AddFunction(
-		buildGenFunc(headerParamSignature, c.host, c.headerType != nil),
-	)
+	converter.AddFunction(buildGenFunc(cfg))
+	converter.AddFunction(buildRequestHeadersFunc(cfg))
+	converter.AddFunction(buildCallSignalFunc())
+	converter.AddFunction(buildTimedOutFunc())
+	converter.AddFunction(buildRetryAfterFunc())
+	if cfg.tracing {
+		converter.AddFunction(buildSetTracingFunc())
+		converter.AddFunction(buildNewTraceparentFunc())
+	}
+	if hasStreams {
+		converter.AddFunction(buildStreamFunc(cfg))
+		converter.AddFunction(buildParseStreamEventFunction())
+	}
+	if hasSockets {
+		converter.AddFunction(buildSocketURLFunc(cfg))
+	}
+	if c.batch.enabled {
+		converter.AddFunction(buildSetAutoBatchingFunc())
+		converter.AddFunction(buildBatchKeyFunc(cfg))
+		converter.AddFunction(buildQueueBatchCallFunc(cfg, c.batch.maxCalls))
+		converter.AddFunction(buildSendBatchFunc(cfg, c.pathPrefix+batchPath))
+	}
+	converter.AddFunction(buildToErrorFunction())
+	converter.AddFunction(buildStatusFromHTTPCodeFunction(c.httpStatus))
 
 	converter.AddFunction(typescriptify.TypeScriptFunction{
 		IsAsync:    false,
@@ -155,8 +880,21 @@ func (c *TinyRPC) genCode() (string, error) {
 	// Export the base response interface
 	code += "\n"
 	code += "export interface Response<T> { Body: T; Status: Status; Headers: Headers; }\n"
-	code += "export interface Error { Message: String; IsError: boolean; Status: Status; }\n"
-	code += "export interface ErrorRes { ErrorMessage: String }\n"
+	code += "export interface Error { Message: String; IsError: boolean; Status: Status; Details?: any; Violations?: FieldViolation[]; RetryAfter?: number; Headers?: Headers; }\n"
+	code += "export interface ErrorRes { ErrorMessage: String; Details?: any; Violations?: FieldViolation[]; RetryAfter?: number; }\n"
+	code += "export interface FieldViolation { Field: string; Rule: string; Message: string; }\n"
+	if cfg.tracing {
+		code += "export interface CallOptions { signal?: AbortSignal; timeout?: number; traceparent?: string; }\n"
+		code += "let tracing = false;\n"
+	} else {
+		code += "export interface CallOptions { signal?: AbortSignal; timeout?: number; }\n"
+	}
+	if hasSockets {
+		code += socketConnectionClass
+	}
+	if c.batch.enabled {
+		code += buildBatchState(cfg)
+	}
 
 	// Export the constants, if there are any. We don't need to export the type
 	// as this is just an object of known shape
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lets frontends served from other origins call the app, e.g. a
+// dev server on another port
+type CORSConfig struct {
+	// The origins that can call the app, e.g. "http://localhost:5173". "*"
+	// allows any origin, but can't be used along with AllowCredentials.
+	AllowedOrigins []string
+	// Request headers to allow on top of the ones the app already reads, which
+	// are the fields of the header types, Content-Type, Tinyrpc-Timeout and,
+	// with tracing, traceparent
+	AllowedHeaders []string
+	// Response headers the client can read, on top of Retry-After, e.g. ones
+	// set with SetHeader
+	ExposedHeaders []string
+	// Whether to allow cookies and other credentials
+	AllowCredentials bool
+	// How long browsers can cache the response to a preflight request. Zero
+	// leaves it up to the browser.
+	MaxAge time.Duration
+}
+
+// WithCORS answers preflight requests for every route and adds the CORS
+// headers to their responses. Socket routes don't have preflight requests, but
+// the allowed origins can open sockets too, see checkSocketOrigin.
+//
+// Allowing credentials from any origin would let every site make calls with
+// the user's cookies, so it panics if "*" is used with AllowCredentials.
+func WithCORS(config CORSConfig) Option {
+	if config.AllowCredentials && slices.Contains(config.AllowedOrigins, "*") {
+		panic("CORS can't allow credentials from any origin, list the allowed origins instead")
+	}
+	return func(c *TinyRPC) {
+		c.cors = &cors{config: config}
+	}
+}
+
+type cors struct {
+	config CORSConfig
+	// Worked out once the routes are attached, see prepareCORS
+	allowHeaders  string
+	exposeHeaders string
+}
+
+// The allowed headers depend on the header types of every route, so they can
+// only be worked out once the app is being prepared
+func (c *TinyRPC) prepareCORS() {
+	headers := []string{"Content-Type", timeoutHeader}
+	if c.tracing != nil {
+		headers = append(headers, traceparentHeader)
+	}
+	headerTypes, _ := c.routeHeaderTypes()
+	for _, headerType := range headerTypes {
+		for _, field := range headerFields(headerType) {
+			headers = append(headers, headerFieldName(field))
+		}
+	}
+	headers = append(headers, c.cors.config.AllowedHeaders...)
+	c.cors.allowHeaders = joinHeaderNames(headers)
+	c.cors.exposeHeaders = joinHeaderNames(append([]string{"Retry-After"}, c.cors.config.ExposedHeaders...))
+}
+
+// Canonicalised and without duplicates, in the order they were given
+func joinHeaderNames(headers []string) string {
+	names := []string{}
+	for _, header := range headers {
+		name := http.CanonicalHeaderKey(header)
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c *cors) allowsOrigin(origin string) bool {
+	for _, allowed := range c.config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the headers that every response to an allowed origin gets
+func (c *cors) setHeaders(w http.ResponseWriter, origin string) {
+	header := w.Header()
+	if slices.Contains(c.config.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// Wraps a route's handler so its responses carry the CORS headers
+func (c *cors) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		// Whether the CORS headers are there depends on the origin, so caches
+		// need to know, even when they aren't
+		w.Header().Add("Vary", "Origin")
+		if origin := req.Header.Get("Origin"); origin != "" && c.allowsOrigin(origin) {
+			c.setHeaders(w, origin)
+			w.Header().Set("Access-Control-Expose-Headers", c.exposeHeaders)
+		}
+		next(w, req)
+	}
+}
+
+func (c *cors) preflight(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	origin := req.Header.Get("Origin")
+	if origin == "" || !c.allowsOrigin(origin) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	c.setHeaders(w, origin)
+	header := w.Header()
+	header.Set("Access-Control-Allow-Methods", http.MethodPost)
+	header.Set("Access-Control-Allow-Headers", c.allowHeaders)
+	if c.config.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.config.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
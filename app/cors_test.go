@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCORS(t *testing.T) {
+	type greetRequest struct{}
+	type greetResponse struct{}
+	type chatClientMessage struct{}
+	type chatServerMessage struct{}
+	type authHeaders struct {
+		Authorization string `json:"Authorization" validate:"nonzero"`
+	}
+	type tenantHeaders struct {
+		Tenant string `json:"X-Tenant"`
+	}
+
+	greetFn := func(ctx context.Context, req greetRequest) (*greetResponse, error) {
+		SetHeader(ctx, "X-Request-Id", "abc")
+		return &greetResponse{}, nil
+	}
+	chatFn := func(ctx context.Context, conn *SocketConn[chatClientMessage, chatServerMessage]) error {
+		return nil
+	}
+
+	Convey("cross-origin frontends can call the app", t, func() {
+		a := New("", "", WithBatching(0), WithCORS(CORSConfig{
+			AllowedOrigins: []string{"http://localhost:5173"},
+			AllowedHeaders: []string{"x-custom"},
+			ExposedHeaders: []string{"X-Request-Id"},
+			MaxAge:         10 * time.Minute,
+		}))
+		a.AddHeaderType(authHeaders{})
+		NewRoute(greetFn).Attach(a)
+		NewRoute(greetFn).WithHeaderType(tenantHeaders{}).Attach(a.Group("admin"))
+		NewSocketRoute(chatFn).Attach(a)
+
+		preflight := func(path string, origin string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("OPTIONS", path, nil)
+			r.Header.Set("Origin", origin)
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("with preflight requests answered for every route", func() {
+			for _, path := range []string{"/tinyrpc/greet", "/tinyrpc/admin/greet", batchPath} {
+				w := preflight(path, "http://localhost:5173")
+				So(w.Code, ShouldEqual, http.StatusNoContent)
+				So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "http://localhost:5173")
+				So(w.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "POST")
+				So(w.Header().Get("Access-Control-Max-Age"), ShouldEqual, "600")
+				So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldBeEmpty)
+				So(w.Header().Values("Vary"), ShouldContain, "Origin")
+			}
+		})
+
+		Convey("with the headers from the header types allowed", func() {
+			w := preflight("/tinyrpc/greet", "http://localhost:5173")
+			So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Content-Type, Tinyrpc-Timeout, Authorization, X-Tenant, X-Custom")
+		})
+
+		Convey("with preflight requests from other origins refused", func() {
+			w := preflight("/tinyrpc/greet", "http://evil.example")
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+			So(w.Header().Values("Vary"), ShouldContain, "Origin")
+		})
+
+		Convey("with responses to other origins varying on the origin too", func() {
+			for _, origin := range []string{"http://evil.example", ""} {
+				r, _ := http.NewRequest("POST", "/tinyrpc/greet", bytes.NewBufferString(`{}`))
+				if origin != "" {
+					r.Header.Set("Origin", origin)
+				}
+				r.Header.Set("Authorization", "token")
+				w := httptest.NewRecorder()
+				a.Handler().ServeHTTP(w, r)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+				So(w.Header().Values("Vary"), ShouldContain, "Origin")
+			}
+		})
+
+		Convey("with the CORS headers on responses", func() {
+			r, _ := http.NewRequest("POST", "/tinyrpc/greet", bytes.NewBufferString(`{}`))
+			r.Header.Set("Origin", "http://localhost:5173")
+			r.Header.Set("Authorization", "token")
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "http://localhost:5173")
+			So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "Retry-After, X-Request-Id")
+		})
+
+		Convey("with sockets from other origins refused", func() {
+			r, _ := http.NewRequest("GET", "/tinyrpc/chat", nil)
+			r.Host = "api.example"
+			r.Header.Set("Origin", "http://evil.example")
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusForbidden)
+			So(w.Header().Values("Vary"), ShouldContain, "Origin")
+		})
+
+		Convey("with sockets from the allowed origins accepted", func() {
+			r, _ := http.NewRequest("GET", "/tinyrpc/chat", nil)
+			r.Host = "api.example"
+			r.Header.Set("Origin", "http://localhost:5173")
+			r.Header.Set("Authorization", "token")
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			// Not an upgrade request, but it got past the origin check
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("but not sockets from the app's own origin", func() {
+			r, _ := http.NewRequest("GET", "/tinyrpc/chat", nil)
+			r.Host = "api.example"
+			r.Header.Set("Origin", "https://api.example")
+			r.Header.Set("Authorization", "token")
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			// Not an upgrade request, but it got past the origin check
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+
+	Convey("any origin can be allowed", t, func() {
+		Convey("without credentials", func() {
+			a := New("", "", WithCORS(CORSConfig{AllowedOrigins: []string{"*"}}))
+			NewRoute(greetFn).Attach(a)
+			r, _ := http.NewRequest("OPTIONS", "/tinyrpc/greet", nil)
+			r.Header.Set("Origin", "http://anywhere.example")
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+		})
+
+		Convey("but not with credentials", func() {
+			So(func() {
+				WithCORS(CORSConfig{AllowedOrigins: []string{"http://localhost:5173", "*"}, AllowCredentials: true})
+			}, ShouldPanic)
+		})
+	})
+
+	Convey("credentials can be allowed from listed origins", t, func() {
+		a := New("", "", WithCORS(CORSConfig{AllowedOrigins: []string{"http://localhost:5173"}, AllowCredentials: true}))
+		NewRoute(greetFn).Attach(a)
+		r, _ := http.NewRequest("OPTIONS", "/tinyrpc/greet", nil)
+		r.Header.Set("Origin", "http://localhost:5173")
+		w := httptest.NewRecorder()
+		a.Handler().ServeHTTP(w, r)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "http://localhost:5173")
+		So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+
+		code, err := a.genCode()
+		So(err, ShouldBeNil)
+		So(code, ShouldContainSubstring, `const requestOptions: RequestInit = { method: "POST", signal: signal, credentials: "include" };`)
+	})
+}
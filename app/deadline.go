@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The generated client sends how long it's willing to wait in this header,
+// in the same format as gRPC's grpc-timeout, e.g. "1500m" for 1.5 seconds
+const timeoutHeader = "Tinyrpc-Timeout"
+
+var errDeadlineExceeded = NewError(STATUS_DEADLINE_EXCEEDED, "deadline exceeded")
+
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// A positive integer of at most 8 digits, followed by the unit. Amounts too
+// big for a time.Duration, like 99999999H, are capped at the longest one.
+func parseTimeout(value string) (time.Duration, error) {
+	digits := value[:max(len(value)-1, 0)]
+	if len(digits) < 1 || len(digits) > 8 || strings.Trim(digits, "0123456789") != "" {
+		return 0, fmt.Errorf("expected up to 8 digits followed by a unit, got %q", value)
+	}
+	unit, ok := timeoutUnits[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q, expected one of H, M, S, m, u or n", value)
+	}
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("expected a positive amount, got %q", value)
+	}
+	if amount > math.MaxInt64/int64(unit) {
+		return time.Duration(math.MaxInt64), nil
+	}
+	return time.Duration(amount) * unit, nil
+}
+
+// The timeout the client sent, or zero if it didn't send one
+func clientTimeout(headers http.Header) (time.Duration, error) {
+	value := headers.Get(timeoutHeader)
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := parseTimeout(value)
+	if err != nil {
+		return 0, Errorf(STATUS_INVALID_ARGUMENT, "invalid %s header: %v", timeoutHeader, err)
+	}
+	return timeout, nil
+}
+
+// Apply the route's timeout or the one the client sent, whichever is sooner.
+// Once it passes the context is cancelled with errDeadlineExceeded.
+func withDeadline(ctx context.Context, query *RouteContainer, headers http.Header) (context.Context, context.CancelFunc, error) {
+	timeout, err := clientTimeout(headers)
+	if err != nil {
+		return ctx, func() {}, err
+	}
+	if timeout <= 0 || (query.Timeout > 0 && query.Timeout < timeout) {
+		timeout = query.Timeout
+	}
+	if timeout <= 0 {
+		return ctx, func() {}, nil
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errDeadlineExceeded)
+	return ctx, cancel, nil
+}
+
+// Once the request has been cut short, e.g. by its deadline passing or the
+// server shutting down, that's the outcome rather than whatever the handler
+// made of it. Cancellations without an *Error cause, like the client going
+// away, leave err as it is.
+func cancelledError(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	var rpcErr *Error
+	if cause := context.Cause(ctx); errors.As(cause, &rpcErr) {
+		return cause
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeadlines(t *testing.T) {
+	Convey("timeouts are parsed in the grpc-timeout format", t, func() {
+		timeout, err := parseTimeout("1500m")
+		So(err, ShouldBeNil)
+		So(timeout, ShouldEqual, 1500*time.Millisecond)
+
+		timeout, err = parseTimeout("2H")
+		So(err, ShouldBeNil)
+		So(timeout, ShouldEqual, 2*time.Hour)
+
+		for _, value := range []string{"", "m", "10", "10s", "0S", "-1S", "+1S", "123456789S"} {
+			_, err = parseTimeout(value)
+			So(err, ShouldNotBeNil)
+		}
+
+		Convey("with amounts too big for a duration capped rather than overflowing", func() {
+			timeout, err := parseTimeout("99999999H")
+			So(err, ShouldBeNil)
+			So(timeout, ShouldEqual, time.Duration(math.MaxInt64))
+
+			timeout, err = parseTimeout("99999999n")
+			So(err, ShouldBeNil)
+			So(timeout, ShouldEqual, 99999999*time.Nanosecond)
+		})
+	})
+
+	Convey("handlers are given a deadline", t, func() {
+		type waitRequest struct{ Ignore bool }
+		type waitResponse struct{ HadDeadline bool }
+		type quickRequest struct{}
+		type quickResponse struct{ Remaining time.Duration }
+		type tickRequest struct{}
+		type tickResponse struct{}
+
+		waitFn := func(ctx context.Context, req waitRequest) (*waitResponse, error) {
+			_, hasDeadline := ctx.Deadline()
+			if req.Ignore {
+				time.Sleep(50 * time.Millisecond)
+				return &waitResponse{HadDeadline: hasDeadline}, nil
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		quickFn := func(ctx context.Context, req quickRequest) (*quickResponse, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				return &quickResponse{}, nil
+			}
+			return &quickResponse{Remaining: time.Until(deadline)}, nil
+		}
+		tickFn := func(ctx context.Context, req tickRequest, send func(*tickResponse) error) error {
+			if err := send(&tickResponse{}); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		a := New("", "", WithBatching(0))
+		NewRoute(waitFn).WithTimeout(20 * time.Millisecond).Attach(a)
+		NewRoute(quickFn).WithTimeout(time.Hour).Attach(a)
+		NewStreamRoute(tickFn).WithTimeout(20 * time.Millisecond).Attach(a)
+
+		call := func(path string, body string, timeout string) (*httptest.ResponseRecorder, Res[ReturnError]) {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			if timeout != "" {
+				r.Header.Set(timeoutHeader, timeout)
+			}
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+
+			var errRes Res[ReturnError]
+			json.Unmarshal(w.Body.Bytes(), &errRes)
+			return w, errRes
+		}
+
+		Convey("with the route's timeout exceeded", func() {
+			w, errRes := call("/tinyrpc/wait", `{}`, "")
+			So(w.Code, ShouldEqual, http.StatusGatewayTimeout)
+			So(errRes.Status, ShouldEqual, STATUS_DEADLINE_EXCEEDED)
+			So(errRes.Body.ErrorMessage, ShouldEqual, "deadline exceeded")
+		})
+
+		Convey("with handlers that ignore the deadline still failing", func() {
+			w, errRes := call("/tinyrpc/wait", `{"Ignore": true}`, "")
+			So(w.Code, ShouldEqual, http.StatusGatewayTimeout)
+			So(errRes.Status, ShouldEqual, STATUS_DEADLINE_EXCEEDED)
+		})
+
+		Convey("with a shorter timeout from the client taking precedence", func() {
+			w, _ := call("/tinyrpc/quick", `{}`, "")
+			var res Res[quickResponse]
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			So(res.Body.Remaining, ShouldBeGreaterThan, 59*time.Minute)
+
+			w, _ = call("/tinyrpc/quick", `{}`, "2S")
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			So(res.Body.Remaining, ShouldBeBetween, time.Second, 2*time.Second)
+		})
+
+		Convey("with invalid timeouts rejected", func() {
+			w, errRes := call("/tinyrpc/quick", `{}`, "soon")
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(errRes.Body.ErrorMessage, ShouldStartWith, "invalid Tinyrpc-Timeout header")
+		})
+
+		Convey("with streams ended by their timeout", func() {
+			w, _ := call("/tinyrpc/tick", `{}`, "")
+			So(w.Body.String(), ShouldEndWith, "event: error\ndata: {\"Body\":{\"ErrorMessage\":\"deadline exceeded\"},\"Status\":4}\n\n")
+		})
+
+		Convey("with the client's timeout applied to each call in a batch", func() {
+			w, _ := call("/tinyrpc/$batch", `[{"Method": "quick", "Params": {}}, {"Method": "wait", "Params": {}}]`, "2S")
+			var results []Res[json.RawMessage]
+			So(json.Unmarshal(w.Body.Bytes(), &results), ShouldBeNil)
+			So(results[0].Status, ShouldEqual, STATUS_OK)
+			So(results[1].Status, ShouldEqual, STATUS_DEADLINE_EXCEEDED)
+		})
+
+		Convey("with the client's timeout counted from the start of the batch", func() {
+			// Only one call runs at a time, so the second waits for the first
+			b := New("", "", WithBatching(1))
+			NewRoute(waitFn).Attach(b)
+			r, _ := http.NewRequest("POST", "/tinyrpc/$batch", bytes.NewBufferString(`[{"Method": "wait", "Params": {"Ignore": true}}, {"Method": "wait", "Params": {"Ignore": true}}]`))
+			r.Header.Set(timeoutHeader, "80m")
+			w := httptest.NewRecorder()
+			b.Handler().ServeHTTP(w, r)
+
+			var results []Res[json.RawMessage]
+			So(json.Unmarshal(w.Body.Bytes(), &results), ShouldBeNil)
+			So(results[0].Status, ShouldEqual, STATUS_OK)
+			So(results[1].Status, ShouldEqual, STATUS_DEADLINE_EXCEEDED)
+		})
+
+		Convey("with an invalid timeout failing the whole batch", func() {
+			w, errRes := call("/tinyrpc/$batch", `[{"Method": "quick", "Params": {}}]`, "soon")
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(errRes.Body.ErrorMessage, ShouldStartWith, "invalid Tinyrpc-Timeout header")
+		})
+
+		Convey("and let the client set a timeout", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export async function wait(params: waitRequest, headers?: HeadersInit, options?: CallOptions): Promise<Response<waitResponse> | Error> {")
+			So(code, ShouldContainSubstring, `r.set("Tinyrpc-Timeout", ms < 1e8 ? ms + "m" : Math.ceil(ms / 1000) + "S");`)
+			So(code, ShouldContainSubstring, "export interface CallOptions { signal?: AbortSignal; timeout?: number; }")
+		})
+	})
+}
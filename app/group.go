@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// RouteTarget is anything a route can be attached to, which is either the app
+// itself or a Group
+type RouteTarget interface {
+	addRoute(rr *RouteContainer) error
+}
+
+// Group is a set of routes that share a path prefix and middleware. Routes are
+// mounted at /tinyrpc/<group>/<subgroup>/<Name>, and the generated functions
+// are namespaced to match, e.g. admin.users.listUsers(...)
+type Group struct {
+	app        *TinyRPC
+	parent     *Group
+	name       string
+	middleware []MiddlewareFn
+	headerType reflect.Type
+	// See UseInterceptor
+	interceptors []InterceptorFn
+}
+
+// Group names end up as Typescript namespaces, so they have to be valid
+// identifiers
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func newGroup(app *TinyRPC, parent *Group, name string, middleware []MiddlewareFn) *Group {
+	if !identifierPattern.MatchString(name) {
+		panic(fmt.Sprintf("Invalid group name %q, group names must be valid Typescript identifiers", name))
+	}
+	return &Group{
+		app:        app,
+		parent:     parent,
+		name:       name,
+		middleware: middleware,
+	}
+}
+
+// Group creates a top level group of routes. The middleware runs for every
+// route attached to the group or any of its subgroups.
+func (c *TinyRPC) Group(name string, middleware ...MiddlewareFn) *Group {
+	return newGroup(c, nil, name, middleware)
+}
+
+// Group creates a subgroup, which inherits the prefix and middleware of this
+// group
+func (g *Group) Group(name string, middleware ...MiddlewareFn) *Group {
+	return newGroup(g.app, g, name, middleware)
+}
+
+// Use adds middleware to the group. It also applies to routes that have
+// already been attached.
+func (g *Group) Use(middleware ...MiddlewareFn) {
+	g.app.prepareMu.Lock()
+	defer g.app.prepareMu.Unlock()
+	if g.app.prepared {
+		panic("Middleware must be registered before the app is started")
+	}
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// AddHeaderType sets the header type for the routes in the group and its
+// subgroups, in place of the app's. See TinyRPC.AddHeaderType.
+func (g *Group) AddHeaderType(header any) {
+	if g.headerType != nil {
+		panic("Header type already set")
+	}
+	headerType, err := resolveHeaderType(header)
+	if err != nil {
+		panic(err.Error())
+	}
+	g.headerType = headerType
+}
+
+func (g *Group) innermostHeaderType() reflect.Type {
+	if g == nil {
+		return nil
+	}
+	if g.headerType != nil {
+		return g.headerType
+	}
+	return g.parent.innermostHeaderType()
+}
+
+// The group names from the outermost group inwards
+func (g *Group) names() []string {
+	if g == nil {
+		return nil
+	}
+	return append(g.parent.names(), g.name)
+}
+
+// The middleware from the outermost group inwards, so that outer groups wrap
+// inner ones
+func (g *Group) allMiddleware() []MiddlewareFn {
+	if g == nil {
+		return nil
+	}
+	return append(g.parent.allMiddleware(), g.middleware...)
+}
+
+// Namespace is the dotted path of the group, e.g. "admin.users"
+func (g *Group) Namespace() string {
+	return strings.Join(g.names(), ".")
+}
+
+func (g *Group) addRoute(rr *RouteContainer) error {
+	rr.Group = g
+	rr.QueryPath = fmt.Sprintf("/tinyrpc/%s/%s", strings.Join(g.names(), "/"), rr.FnName)
+	return g.app.addHandler(rr)
+}
+
+func (c *TinyRPC) addRoute(rr *RouteContainer) error {
+	return c.addHandler(rr)
+}
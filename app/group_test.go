@@ -0,0 +1,87 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGroups(t *testing.T) {
+	Convey("routes can be attached to nested groups", t, func() {
+		type listUsersRequest struct{}
+		type listUsersResponse struct{ Names []string }
+
+		listUsersFn := func(ctx context.Context, req listUsersRequest) (*listUsersResponse, error) {
+			return &listUsersResponse{Names: []string{"someone"}}, nil
+		}
+
+		calls := []string{}
+		recordingMiddleware := func(name string) MiddlewareFn {
+			return func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+				calls = append(calls, name+":"+method)
+				return handler(ctx, req)
+			}
+		}
+
+		a := New("localhost:8000", "")
+		admin := a.Group("admin", recordingMiddleware("admin"))
+		users := admin.Group("users", recordingMiddleware("users"))
+		NewRoute(listUsersFn).AttachWithMiddleware(users, recordingMiddleware("route"))
+		So(a.handlers[0].QueryPath, ShouldEqual, "/tinyrpc/admin/users/listUsers")
+
+		Convey("inheriting the group middleware, outermost first", func() {
+			srv := httptest.NewServer(a.Handler())
+			defer srv.Close()
+
+			res, err := http.Post(srv.URL+"/tinyrpc/admin/users/listUsers", "application/json", bytes.NewBufferString("{}"))
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+
+			var body Res[listUsersResponse]
+			So(json.NewDecoder(res.Body).Decode(&body), ShouldBeNil)
+			So(body.Body.Names, ShouldResemble, []string{"someone"})
+			So(calls, ShouldResemble, []string{"admin:listUsers", "users:listUsers", "route:listUsers"})
+		})
+
+		Convey("with middleware added after the route was attached", func() {
+			admin.Use(recordingMiddleware("late"))
+			srv := httptest.NewServer(a.Handler())
+			defer srv.Close()
+
+			res, err := http.Post(srv.URL+"/tinyrpc/admin/users/listUsers", "application/json", bytes.NewBufferString("{}"))
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(calls, ShouldResemble, []string{"admin:listUsers", "late:listUsers", "users:listUsers", "route:listUsers"})
+		})
+
+		Convey("and the generated functions are namespaced", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export namespace admin.users {\n\texport async function listUsers(")
+			So(code, ShouldContainSubstring, `"/tinyrpc/admin/users/listUsers"`)
+		})
+
+		Convey("and nothing can be added once the app is running", func() {
+			type getUserRequest struct{}
+			type getUserResponse struct{}
+			getUserFn := func(ctx context.Context, req getUserRequest) (*getUserResponse, error) {
+				return &getUserResponse{}, nil
+			}
+
+			a.Handler()
+			So(func() { admin.Use(recordingMiddleware("late")) }, ShouldPanic)
+			So(func() { NewRoute(getUserFn).Attach(users) }, ShouldPanic)
+			So(func() { NewRoute(getUserFn).Attach(a) }, ShouldPanic)
+			So(NewRoute(getUserFn).Register(a), ShouldNotBeNil)
+		})
+	})
+
+	Convey("group names have to be valid identifiers", t, func() {
+		So(func() { New("", "").Group("not-valid") }, ShouldPanic)
+	})
+}
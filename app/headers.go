@@ -0,0 +1,279 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/concolorcarne/tinyrpc/typescriptify"
+)
+
+// The header structs decoded for the request, keyed by their type. Decoding
+// errors are kept until checkHeaders, so that middleware that runs before it
+// still sees the request.
+type decodedHeaders map[reflect.Type]decodedHeader
+
+type decodedHeader struct {
+	value any
+	err   error
+}
+
+type tinyRPCDecodedHeadersValue struct{}
+
+var tinyRPCDecodedHeadersKey = tinyRPCDecodedHeadersValue{}
+
+// Headers returns the request's headers decoded into T, which has to be the
+// header type that applies to the route. It returns nil if it's a different
+// type or the headers couldn't be decoded, in which case the request fails
+// before it reaches the handler.
+func Headers[T any](ctx context.Context) *T {
+	decoded, _ := ctx.Value(tinyRPCDecodedHeadersKey).(decodedHeaders)
+	header, ok := decoded[reflect.TypeFor[T]()]
+	if !ok || header.err != nil {
+		return nil
+	}
+	return header.value.(*T)
+}
+
+// Runs after tracing and metrics, but before the app, group and route
+// middleware, so that all of it can use Headers
+func decodeHeaders(headerType reflect.Type) MiddlewareFn {
+	return func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+		value, err := decodeHeaderStruct(headerType, getHeaders(ctx))
+
+		existing, _ := ctx.Value(tinyRPCDecodedHeadersKey).(decodedHeaders)
+		decoded := make(decodedHeaders, len(existing)+1)
+		for typ, header := range existing {
+			decoded[typ] = header
+		}
+		decoded[headerType] = decodedHeader{value: value, err: err}
+
+		return handler(context.WithValue(ctx, tinyRPCDecodedHeadersKey, decoded), req)
+	}
+}
+
+// Fail the request if any of the headers couldn't be decoded. This runs after
+// the app middleware, so that e.g. logging middleware still sees the request.
+func checkHeaders(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+	decoded, _ := ctx.Value(tinyRPCDecodedHeadersKey).(decodedHeaders)
+	for _, header := range decoded {
+		if header.err != nil {
+			return nil, header.err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// Header fields are named and made required the same way as in the generated
+// Typescript, so the client's types match what the server checks
+func headerFieldName(field reflect.StructField) string {
+	return typescriptify.JSONFieldName(field, "json")
+}
+
+func isRequiredHeader(field reflect.StructField) bool {
+	return typescriptify.IsRequiredField(field)
+}
+
+// The fields that are read from the headers, including those of embedded
+// structs
+func headerFields(typ reflect.Type) []reflect.StructField {
+	fields := []reflect.StructField{}
+	for _, field := range reflect.VisibleFields(typ) {
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			// Its fields are visited separately
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// The header type that applies to the route, which is the route's own, the
+// innermost group's or the app's, in that order
+func (c *TinyRPC) routeHeaderType(query *RouteContainer) reflect.Type {
+	if query.HeaderType != nil {
+		return query.HeaderType
+	}
+	if headerType := query.Group.innermostHeaderType(); headerType != nil {
+		return headerType
+	}
+	return c.headerType
+}
+
+// Whether the generated function has to be passed the headers
+func hasRequiredHeaders(typ reflect.Type) bool {
+	for _, field := range headerFields(typ) {
+		if isRequiredHeader(field) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveHeaderType(header any) (reflect.Type, error) {
+	headerType := derefType(reflect.TypeOf(header))
+	if headerType == nil || headerType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("header type must be a struct, got %T", header)
+	}
+	if err := checkHeaderType(headerType); err != nil {
+		return nil, err
+	}
+	return headerType, nil
+}
+
+// Check the header struct can be decoded, so that problems show up when it's
+// registered rather than on the first request
+func checkHeaderType(typ reflect.Type) error {
+	for _, field := range headerFields(typ) {
+		if err := checkHeaderFieldType(field.Type); err != nil {
+			return fmt.Errorf("header field %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+func checkHeaderFieldType(typ reflect.Type) error {
+	if typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s, headers can only be decoded into strings, numbers, bools and pointers or slices of those", typ)
+}
+
+// Decode the headers into a new instance of the struct and validate it.
+// Missing required headers are UNAUTHENTICATED, as they're usually
+// credentials, whereas headers that are present but malformed are
+// INVALID_ARGUMENT.
+func decodeHeaderStruct(typ reflect.Type, headers http.Header) (any, error) {
+	value := reflect.New(typ)
+
+	missing := []FieldViolation{}
+	malformed := []FieldViolation{}
+	for _, field := range headerFields(typ) {
+		name := headerFieldName(field)
+		values := headers.Values(name)
+		if len(values) == 0 {
+			if isRequiredHeader(field) {
+				missing = append(missing, FieldViolation{
+					Field:   name,
+					Rule:    "required",
+					Message: "missing required header",
+				})
+			}
+			continue
+		}
+
+		err := setHeaderField(value.Elem().FieldByIndex(field.Index), values)
+		if err != nil {
+			malformed = append(malformed, FieldViolation{
+				Field:   name,
+				Rule:    "invalid",
+				Message: err.Error(),
+			})
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, &Error{
+			Status:     STATUS_UNAUTHENTICATED,
+			Message:    "missing required header(s): " + violationFields(missing),
+			Violations: missing,
+		}
+	}
+	if len(malformed) > 0 {
+		return nil, &Error{
+			Status:     STATUS_INVALID_ARGUMENT,
+			Message:    "invalid header(s): " + violationFields(malformed),
+			Violations: malformed,
+		}
+	}
+
+	if err := validateRequest(value.Elem().Interface()); err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+func violationFields(violations []FieldViolation) string {
+	fields := make([]string, len(violations))
+	for idx, violation := range violations {
+		fields[idx] = violation.Field
+	}
+	return strings.Join(fields, ", ")
+}
+
+func setHeaderField(field reflect.Value, values []string) error {
+	switch field.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setHeaderField(elem.Elem(), values); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	case reflect.Slice:
+		// Values can be sent as separate headers or comma separated in one
+		items := []string{}
+		for _, value := range values {
+			for _, item := range strings.Split(value, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for idx, item := range items {
+			if err := setHeaderValue(slice.Index(idx), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	default:
+		return setHeaderValue(field, values[0])
+	}
+}
+
+func setHeaderValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected a bool, got %q", value)
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a positive integer, got %q", value)
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
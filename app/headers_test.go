@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTypedHeaders(t *testing.T) {
+	Convey("the registered header type is decoded for every request", t, func() {
+		type authHeaders struct {
+			Token   string   `json:"token" validate:"required"`
+			Version *int     `json:"x-version" validate:"min=1"`
+			Debug   bool     `json:"x-debug"`
+			Tags    []string `json:"x-tags"`
+		}
+		type whoAmIRequest struct{}
+		type whoAmIResponse struct {
+			Token   string
+			Version int
+			Tags    []string
+		}
+
+		whoAmIFn := func(ctx context.Context, req whoAmIRequest) (*whoAmIResponse, error) {
+			headers := Headers[authHeaders](ctx)
+			res := &whoAmIResponse{Token: headers.Token, Tags: headers.Tags}
+			if headers.Version != nil {
+				res.Version = *headers.Version
+			}
+			return res, nil
+		}
+
+		var middlewareSawHeaders *authHeaders
+		var middlewareCalls int
+		a := New("", "")
+		a.AddHeaderType(authHeaders{})
+		a.Use(func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			middlewareCalls++
+			middlewareSawHeaders = Headers[authHeaders](ctx)
+			return handler(ctx, req)
+		})
+		NewRoute(whoAmIFn).Attach(a)
+
+		call := func(headers map[string][]string) (int, Res[json.RawMessage], Res[ReturnError]) {
+			r, _ := http.NewRequest("POST", "/tinyrpc/whoAmI", bytes.NewBufferString("{}"))
+			for key, values := range headers {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+
+			var res Res[json.RawMessage]
+			var errRes Res[ReturnError]
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			json.Unmarshal(w.Body.Bytes(), &errRes)
+			return w.Code, res, errRes
+		}
+
+		Convey("with the fields named the same as in the generated code", func() {
+			code, res, _ := call(map[string][]string{
+				"token":     {"abc"},
+				"X-Version": {"2"},
+				"X-Tags":    {"a, b", "c"},
+			})
+			So(code, ShouldEqual, http.StatusOK)
+			So(string(res.Body), ShouldEqual, `{"Token":"abc","Version":2,"Tags":["a","b","c"]}`)
+			So(middlewareSawHeaders, ShouldNotBeNil)
+			So(middlewareSawHeaders.Token, ShouldEqual, "abc")
+		})
+
+		Convey("with missing required headers unauthenticated", func() {
+			code, _, errRes := call(nil)
+			So(code, ShouldEqual, http.StatusUnauthorized)
+			So(errRes.Status, ShouldEqual, STATUS_UNAUTHENTICATED)
+			So(errRes.Body.ErrorMessage, ShouldEqual, "missing required header(s): token")
+			So(errRes.Body.Violations, ShouldResemble, []FieldViolation{{Field: "token", Rule: "required", Message: "missing required header"}})
+
+			// The app middleware still ran, just without the decoded headers
+			So(middlewareCalls, ShouldEqual, 1)
+			So(middlewareSawHeaders, ShouldBeNil)
+		})
+
+		Convey("with malformed headers invalid", func() {
+			code, _, errRes := call(map[string][]string{"token": {"abc"}, "x-debug": {"maybe"}})
+			So(code, ShouldEqual, http.StatusBadRequest)
+			So(errRes.Body.Violations[0].Field, ShouldEqual, "x-debug")
+
+			_, _, errRes = call(map[string][]string{"token": {"abc"}, "x-version": {"0"}})
+			So(errRes.Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
+			So(errRes.Body.Violations[0].Field, ShouldEqual, "x-version")
+			So(errRes.Body.Violations[0].Rule, ShouldEqual, "min")
+		})
+
+		Convey("with present but empty required headers invalid", func() {
+			_, _, errRes := call(map[string][]string{"token": {""}})
+			So(errRes.Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
+			So(errRes.Body.Violations[0].Rule, ShouldEqual, "required")
+		})
+	})
+
+	Convey("all of the values of a header are kept", t, func() {
+		headers := http.Header{}
+		headers.Add("Accept", "text/html")
+		headers.Add("Accept", "application/json")
+		ctx := addHeadersToContext(context.Background(), headers)
+		So(GetHeader(ctx, "accept"), ShouldEqual, "text/html")
+		So(GetHeaderValues(ctx, "accept"), ShouldResemble, []string{"text/html", "application/json"})
+	})
+
+	Convey("routes and groups can have their own header types", t, func() {
+		type authHeaders struct {
+			Token string `json:"token" validate:"nonzero"`
+		}
+		type adminHeaders struct {
+			Token string `json:"token" validate:"required"`
+			Role  string `json:"x-role" validate:"required"`
+		}
+		type publicHeaders struct {
+			Locale string `json:"accept-language"`
+		}
+		type statusRequest struct{}
+		type statusResponse struct{ Locale string }
+		type whoAmIRequest struct{}
+		type whoAmIResponse struct{ Token string }
+		type promoteRequest struct{}
+		type promoteResponse struct{ Role string }
+
+		statusFn := func(ctx context.Context, req statusRequest) (*statusResponse, error) {
+			So(Headers[authHeaders](ctx), ShouldBeNil)
+			return &statusResponse{Locale: Headers[publicHeaders](ctx).Locale}, nil
+		}
+		whoAmIFn := func(ctx context.Context, req whoAmIRequest) (*whoAmIResponse, error) {
+			return &whoAmIResponse{Token: Headers[authHeaders](ctx).Token}, nil
+		}
+		promoteFn := func(ctx context.Context, req promoteRequest) (*promoteResponse, error) {
+			return &promoteResponse{Role: Headers[adminHeaders](ctx).Role}, nil
+		}
+
+		a := New("", "")
+		a.AddHeaderType(authHeaders{})
+		NewRoute(statusFn).WithHeaderType(publicHeaders{}).Attach(a)
+		NewRoute(whoAmIFn).Attach(a)
+		admin := a.Group("admin")
+		admin.AddHeaderType(&adminHeaders{})
+		NewRoute(promoteFn).Attach(admin.Group("users"))
+
+		call := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString("{}"))
+			for key, value := range headers {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("with the route's own type used instead of the app's", func() {
+			w := call("/tinyrpc/status", map[string]string{"Accept-Language": "en"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, `{"Body":{"Locale":"en"},"Status":0}`)
+
+			w = call("/tinyrpc/whoAmI", nil)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("with the group's type used for routes in subgroups", func() {
+			w := call("/tinyrpc/admin/users/promote", map[string]string{"token": "abc"})
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Body.String(), ShouldContainSubstring, "missing required header(s): x-role")
+
+			w = call("/tinyrpc/admin/users/promote", map[string]string{"token": "abc", "x-role": "owner"})
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, `{"Body":{"Role":"owner"},"Status":0}`)
+		})
+
+		Convey("with the headers parameter only required when there are required headers", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export async function status(params: statusRequest, headers?: publicHeaders, options?: CallOptions): Promise<Response<statusResponse> | Error> {")
+			So(code, ShouldContainSubstring, "export async function whoAmI(params: whoAmIRequest, headers: authHeaders, options?: CallOptions): Promise<Response<whoAmIResponse> | Error> {")
+			So(code, ShouldContainSubstring, "export async function promote(params: promoteRequest, headers: adminHeaders, options?: CallOptions): Promise<Response<promoteResponse> | Error> {")
+			So(code, ShouldContainSubstring, "function convertHeaders(headers?: authHeaders | publicHeaders | adminHeaders): Record<string, string> {")
+			So(code, ShouldNotContainSubstring, "instanceof Headers")
+			So(code, ShouldContainSubstring, "export interface adminHeaders {")
+		})
+
+		Convey("with headers tagged nonzero required in the header interface too", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export interface authHeaders {\n    token: string;\n}")
+		})
+	})
+
+	Convey("routes without a header type still take any headers", t, func() {
+		type authHeaders struct {
+			Token string `json:"token" validate:"required"`
+		}
+		type statusRequest struct{}
+		type statusResponse struct{}
+		type whoAmIRequest struct{}
+		type whoAmIResponse struct{}
+
+		a := New("", "")
+		NewRoute(func(ctx context.Context, req statusRequest) (*statusResponse, error) {
+			return &statusResponse{}, nil
+		}).Attach(a)
+		NewStreamRoute(func(ctx context.Context, req whoAmIRequest, send func(*whoAmIResponse) error) error {
+			return nil
+		}).WithHeaderType(authHeaders{}).Attach(a)
+
+		code, err := a.genCode()
+		So(err, ShouldBeNil)
+		So(code, ShouldContainSubstring, "export async function status(params: statusRequest, headers?: HeadersInit, options?: CallOptions): Promise<Response<statusResponse> | Error> {")
+		So(code, ShouldContainSubstring, "export function whoAmI(params: whoAmIRequest, headers: authHeaders, options?: CallOptions)")
+		So(code, ShouldContainSubstring, "function convertHeaders(headers?: authHeaders | HeadersInit): Record<string, string> {")
+		So(code, ShouldContainSubstring, "if (headers instanceof Headers || Array.isArray(headers)) {")
+	})
+
+	Convey("header types that can't be decoded are rejected up front", t, func() {
+		type badHeaders struct {
+			Nested struct{ Value string }
+		}
+		So(func() { New("", "").AddHeaderType(badHeaders{}) }, ShouldPanicWith, "header field Nested: unsupported type struct { Value string }, headers can only be decoded into strings, numbers, bools and pointers or slices of those")
+		So(func() { New("", "").Group("admin").AddHeaderType("token") }, ShouldPanicWith, "header type must be a struct, got string")
+
+		type getUserRequest struct{}
+		type getUserResponse struct{}
+		err := NewRoute(func(ctx context.Context, req getUserRequest) (*getUserResponse, error) {
+			return nil, nil
+		}).WithHeaderType(badHeaders{}).Register(New("", ""))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "getUser: header field Nested")
+	})
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"reflect"
+)
+
+// RouteInfo describes the route that's handling the call
+type RouteInfo struct {
+	// The name the route is called by in a batch, e.g. "admin.users.listUsers"
+	Method string
+	// The route's own name, without its group's namespace
+	Name       string
+	Path       string
+	Kind       RouteKind
+	InputType  reflect.Type
+	OutputType reflect.Type
+	// The group the route was attached to, if any
+	Group *Group
+}
+
+// Unlike middleware, interceptors run once the request has been decoded and
+// validated, and before the response is marshalled. req is the route's input
+// struct and the response is a pointer to its output struct, so they can be
+// inspected or replaced, and returning without calling handler skips the
+// route's handler altogether. They only run for unary routes.
+type InterceptorFn = func(ctx context.Context, req any, info RouteInfo, handler InterceptorHandler) (any, error)
+type InterceptorHandler = func(ctx context.Context, req any) (any, error)
+
+// Interceptor is an InterceptorFn for a single route, with the route's own
+// input and output types. See Route.Intercept.
+type Interceptor[input any, output any] func(ctx context.Context, req input, info RouteInfo, handler RouteHandler[input, output]) (*output, error)
+
+// UseInterceptor registers interceptors that wrap every unary route on the
+// app, including the ones that have already been attached. App interceptors
+// run first, followed by any group interceptors and then the route's own.
+func (c *TinyRPC) UseInterceptor(interceptors ...InterceptorFn) {
+	c.prepareMu.Lock()
+	defer c.prepareMu.Unlock()
+	if c.prepared {
+		panic("Interceptors must be registered before the app is started")
+	}
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// UseInterceptor adds interceptors to the group. Like Use, they also apply to
+// routes that have already been attached.
+func (g *Group) UseInterceptor(interceptors ...InterceptorFn) {
+	g.app.prepareMu.Lock()
+	defer g.app.prepareMu.Unlock()
+	if g.app.prepared {
+		panic("Interceptors must be registered before the app is started")
+	}
+	g.interceptors = append(g.interceptors, interceptors...)
+}
+
+// The interceptors from the outermost group inwards
+func (g *Group) allInterceptors() []InterceptorFn {
+	if g == nil {
+		return nil
+	}
+	return append(g.parent.allInterceptors(), g.interceptors...)
+}
+
+// Intercept adds interceptors that only apply to this route. They run after
+// the app's and group's interceptors, in the order they're given.
+func (p *Route[input, output]) Intercept(interceptors ...Interceptor[input, output]) *Route[input, output] {
+	p.interceptors = append(p.interceptors, interceptors...)
+	return p
+}
+
+// Wrap the route's handler with its own interceptors, which are typed so they
+// can be applied when the route is attached
+func interceptHandler[input any, output any](handler RouteHandler[input, output], interceptors []Interceptor[input, output]) RouteHandler[input, output] {
+	for idx := len(interceptors) - 1; idx >= 0; idx-- {
+		interceptor, next := interceptors[idx], handler
+		handler = func(ctx context.Context, req input) (*output, error) {
+			info, _ := GetRouteInfo(ctx)
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	return handler
+}
+
+// The route, along with the app and group interceptors, is put in the context
+// by chainMiddleware, as they're only known once the app is being prepared
+type routeContext struct {
+	info         RouteInfo
+	interceptors []InterceptorFn
+}
+
+type tinyRPCRouteValue struct{}
+
+var tinyRPCRouteKey = tinyRPCRouteValue{}
+
+func (c *TinyRPC) newRouteContext(query *RouteContainer) *routeContext {
+	interceptors := append([]InterceptorFn{}, c.interceptors...)
+	return &routeContext{
+		info: RouteInfo{
+			Method:     query.qualifiedName(),
+			Name:       query.FnName,
+			Path:       query.QueryPath,
+			Kind:       query.Kind,
+			InputType:  query.InputType,
+			OutputType: query.OutputType,
+			Group:      query.Group,
+		},
+		interceptors: append(interceptors, query.Group.allInterceptors()...),
+	}
+}
+
+func addRouteToContext(ctx context.Context, route *routeContext) context.Context {
+	return context.WithValue(ctx, tinyRPCRouteKey, route)
+}
+
+// GetRouteInfo returns the route that's handling the call. It's available to
+// middleware as well as interceptors and handlers.
+func GetRouteInfo(ctx context.Context) (RouteInfo, bool) {
+	route, ok := ctx.Value(tinyRPCRouteKey).(*routeContext)
+	if !ok {
+		return RouteInfo{}, false
+	}
+	return route.info, true
+}
+
+// Run the app and group interceptors around the handler. They deal in any, so
+// whatever they pass on or return is checked against the route's types.
+func runInterceptors[inputType any, outputType any](ctx context.Context, req inputType, queryFunc func(context.Context, inputType) (outputType, error)) (outputType, error) {
+	route, _ := ctx.Value(tinyRPCRouteKey).(*routeContext)
+	if route == nil || len(route.interceptors) == 0 {
+		return queryFunc(ctx, req)
+	}
+
+	var handler InterceptorHandler = func(ctx context.Context, req any) (any, error) {
+		typed, ok := req.(inputType)
+		if !ok {
+			return nil, Errorf(STATUS_INTERNAL, "interceptor passed %T to the handler, expected %T", req, typed)
+		}
+		return queryFunc(ctx, typed)
+	}
+	for idx := len(route.interceptors) - 1; idx >= 0; idx-- {
+		interceptor, next := route.interceptors[idx], handler
+		handler = func(ctx context.Context, req any) (any, error) {
+			return interceptor(ctx, req, route.info, next)
+		}
+	}
+
+	var typed outputType
+	res, err := handler(ctx, req)
+	if err != nil || res == nil {
+		return typed, err
+	}
+	typed, ok := res.(outputType)
+	if !ok {
+		return typed, Errorf(STATUS_INTERNAL, "interceptor returned %T, expected %T", res, typed)
+	}
+	return typed, nil
+}
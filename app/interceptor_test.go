@@ -0,0 +1,178 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInterceptors(t *testing.T) {
+	type renameRequest struct {
+		Name string `validate:"nonzero"`
+	}
+	type renameResponse struct {
+		Name string
+	}
+
+	Convey("interceptors see the decoded request and the response", t, func() {
+		calls := []string{}
+		renameFn := func(ctx context.Context, req renameRequest) (*renameResponse, error) {
+			calls = append(calls, "handler")
+			if req.Name == "fail" {
+				return nil, NewError(STATUS_FAILED_PRECONDITION, "can't rename")
+			}
+			return &renameResponse{Name: req.Name}, nil
+		}
+
+		audit := []string{}
+		var seen RouteInfo
+		a := New("", "", WithBatching(0))
+		a.UseInterceptor(func(ctx context.Context, req any, info RouteInfo, handler InterceptorHandler) (any, error) {
+			calls = append(calls, "app")
+			res, err := handler(ctx, req)
+			entry := info.Method + " " + req.(renameRequest).Name
+			if err != nil {
+				entry += " failed with " + StatusFromError(err).TSName()
+			} else {
+				entry += " -> " + res.(*renameResponse).Name
+			}
+			audit = append(audit, entry)
+			return res, err
+		})
+		admin := a.Group("admin")
+		admin.UseInterceptor(func(ctx context.Context, req any, info RouteInfo, handler InterceptorHandler) (any, error) {
+			calls = append(calls, "group")
+			return handler(ctx, req)
+		})
+		NewRoute(renameFn).Intercept(
+			func(ctx context.Context, req renameRequest, info RouteInfo, handler RouteHandler[renameRequest, renameResponse]) (*renameResponse, error) {
+				calls = append(calls, "route")
+				seen = info
+				req.Name = strings.TrimSpace(req.Name)
+				res, err := handler(ctx, req)
+				if res != nil {
+					res.Name = strings.ToUpper(res.Name)
+				}
+				return res, err
+			},
+		).Attach(admin)
+
+		call := func(path string, body string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("running app, group and then route interceptors", func() {
+			w := call("/tinyrpc/admin/rename", `{"Name": " sam "}`)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(calls, ShouldResemble, []string{"app", "group", "route", "handler"})
+
+			var res Res[renameResponse]
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			So(res.Body.Name, ShouldEqual, "SAM")
+			So(audit, ShouldResemble, []string{"admin.rename  sam  -> SAM"})
+			So(seen.Path, ShouldEqual, "/tinyrpc/admin/rename")
+			So(seen.Name, ShouldEqual, "rename")
+			So(seen.Group, ShouldEqual, admin)
+		})
+
+		Convey("with errors from the handler", func() {
+			w := call("/tinyrpc/admin/rename", `{"Name": "fail"}`)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(audit, ShouldResemble, []string{"admin.rename fail failed with STATUS_FAILED_PRECONDITION"})
+		})
+
+		Convey("only once the request is valid", func() {
+			w := call("/tinyrpc/admin/rename", `{}`)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(calls, ShouldBeEmpty)
+		})
+
+		Convey("for calls in a batch too", func() {
+			call("/tinyrpc/$batch", `[{"Method": "admin.rename", "Params": {"Name": "a"}}]`)
+			So(audit, ShouldResemble, []string{"admin.rename a -> A"})
+		})
+
+		Convey("which can't be registered once the app is running", func() {
+			a.Handler()
+			noop := func(ctx context.Context, req any, info RouteInfo, handler InterceptorHandler) (any, error) {
+				return handler(ctx, req)
+			}
+			So(func() { a.UseInterceptor(noop) }, ShouldPanic)
+			So(func() { admin.UseInterceptor(noop) }, ShouldPanic)
+		})
+	})
+
+	Convey("interceptors can skip the handler", t, func() {
+		handled := false
+		renameFn := func(ctx context.Context, req renameRequest) (*renameResponse, error) {
+			handled = true
+			return &renameResponse{Name: req.Name}, nil
+		}
+		a := New("", "")
+		NewRoute(renameFn).Attach(a)
+
+		call := func() *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", "/tinyrpc/rename", bytes.NewBufferString(`{"Name": "sam"}`))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("returning their own response", func() {
+			a.UseInterceptor(func(ctx context.Context, req any, info RouteInfo, handler InterceptorHandler) (any, error) {
+				return &renameResponse{Name: "cached"}, nil
+			})
+			w := call()
+			So(handled, ShouldBeFalse)
+			So(w.Body.String(), ShouldContainSubstring, `"Name":"cached"`)
+		})
+
+		Convey("or an error", func() {
+			a.UseInterceptor(func(ctx context.Context, req any, info RouteInfo, handler InterceptorHandler) (any, error) {
+				return nil, NewError(STATUS_PERMISSION_DENIED, "nope")
+			})
+			So(call().Code, ShouldEqual, http.StatusForbidden)
+			So(handled, ShouldBeFalse)
+		})
+
+		Convey("but returning the wrong type is an internal error", func() {
+			a.UseInterceptor(func(ctx context.Context, req any, info RouteInfo, handler InterceptorHandler) (any, error) {
+				return "nope", nil
+			})
+			w := call()
+			So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			So(w.Body.String(), ShouldContainSubstring, "interceptor returned string")
+		})
+	})
+
+	Convey("middleware can see which route it's running for", t, func() {
+		renameFn := func(ctx context.Context, req renameRequest) (*renameResponse, error) {
+			return &renameResponse{}, nil
+		}
+		var seen RouteInfo
+		a := New("", "")
+		a.Use(func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			seen, _ = GetRouteInfo(ctx)
+			return handler(ctx, req)
+		})
+		NewRoute(renameFn).Attach(a.Group("users"))
+
+		r, _ := http.NewRequest("POST", "/tinyrpc/users/rename", bytes.NewBufferString(`{"Name": "sam"}`))
+		a.Handler().ServeHTTP(httptest.NewRecorder(), r)
+		So(seen.Method, ShouldEqual, "users.rename")
+		So(seen.Kind, ShouldEqual, RouteUnary)
+		So(seen.InputType.Name(), ShouldEqual, "renameRequest")
+
+		_, ok := GetRouteInfo(context.Background())
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// WithLogger sets where the app logs to, e.g. the routes it attaches, panics
+// recovered from handlers and, unless WithErrorLog is set, errors from the
+// HTTP server. Defaults to slog.Default(). To silence the app, pass a logger
+// with a handler that discards everything.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *TinyRPC) {
+		c.log = logger
+	}
+}
+
+func (c *TinyRPC) logger() *slog.Logger {
+	if c.log != nil {
+		return c.log
+	}
+	return slog.Default()
+}
+
+// AccessLog is middleware that logs every call once it's finished, with its
+// method, status, duration and the size of the request body. Failed calls are
+// logged as warnings. Register it first with Use so the duration covers the
+// rest of the middleware. If logger is nil, slog.Default() is used.
+func AccessLog(logger *slog.Logger) MiddlewareFn {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+		start := time.Now()
+		req, body := countBody(req)
+
+		res, err := handler(ctx, req)
+
+		status := responseStatus(ctx, err)
+		attrs := []slog.Attr{
+			slog.String("method", method),
+			slog.String("status", status.TSName()),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int64("request_bytes", body.n),
+		}
+		level := slog.LevelInfo
+		if status != STATUS_OK {
+			level = slog.LevelWarn
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		logger.LogAttrs(ctx, level, "handled request", attrs...)
+		return res, err
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogging(t *testing.T) {
+	Convey("the app logs through the logger it's given", t, func() {
+		type greetRequest struct {
+			Name string `validate:"nonzero"`
+		}
+		type greetResponse struct{ Greeting string }
+
+		greetFn := func(ctx context.Context, req greetRequest) (*greetResponse, error) {
+			return &greetResponse{Greeting: "hi " + req.Name}, nil
+		}
+
+		var logged bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		a := New("", "", WithLogger(logger), WithBatching(0))
+		a.Use(AccessLog(logger))
+		NewRoute(greetFn).Attach(a)
+
+		call := func(path string, body string) {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+		}
+		entries := func(msg string) []map[string]any {
+			found := []map[string]any{}
+			for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+				var entry map[string]any
+				So(json.Unmarshal([]byte(line), &entry), ShouldBeNil)
+				if entry["msg"] == msg {
+					found = append(found, entry)
+				}
+			}
+			return found
+		}
+
+		Convey("with the routes logged as they're attached", func() {
+			a.Handler()
+			attached := entries("attached route")
+			So(attached, ShouldHaveLength, 1)
+			So(attached[0]["path"], ShouldEqual, "/tinyrpc/greet")
+			So(attached[0]["level"], ShouldEqual, "DEBUG")
+		})
+
+		Convey("with an access log entry for each call", func() {
+			body := `{"Name": "sam"}`
+			call("/tinyrpc/greet", body)
+			call("/tinyrpc/greet", `{}`)
+
+			handled := entries("handled request")
+			So(handled, ShouldHaveLength, 2)
+			So(handled[0]["level"], ShouldEqual, "INFO")
+			So(handled[0]["method"], ShouldEqual, "greet")
+			So(handled[0]["status"], ShouldEqual, "STATUS_OK")
+			So(handled[0]["request_bytes"], ShouldEqual, len(body))
+			So(handled[0]["duration"], ShouldBeGreaterThan, 0)
+
+			So(handled[1]["level"], ShouldEqual, "WARN")
+			So(handled[1]["status"], ShouldEqual, "STATUS_INVALID_ARGUMENT")
+		})
+
+		Convey("with each call in a batch logged separately", func() {
+			call("/tinyrpc/$batch", `[{"Method": "greet", "Params": {"Name": "a"}}, {"Method": "greet", "Params": {"Name": "b"}}]`)
+			So(entries("handled request"), ShouldHaveLength, 2)
+		})
+
+		Convey("with requests for unknown routes logged at debug", func() {
+			call("/nope", "")
+			notFound := entries("not found")
+			So(notFound, ShouldHaveLength, 1)
+			So(notFound[0]["path"], ShouldEqual, "/nope")
+		})
+	})
+
+	Convey("errors from middleware are logged with their status", t, func() {
+		type greetRequest struct{}
+		type greetResponse struct{}
+
+		var logged bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logged, nil))
+		a := New("", "", WithLogger(logger))
+		a.Use(AccessLog(logger), func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			return nil, NewError(STATUS_PERMISSION_DENIED, "nope")
+		})
+		NewRoute(func(ctx context.Context, req greetRequest) (*greetResponse, error) {
+			return &greetResponse{}, nil
+		}).Attach(a)
+
+		r, _ := http.NewRequest("POST", "/tinyrpc/greet", bytes.NewBufferString(`{}`))
+		a.Handler().ServeHTTP(httptest.NewRecorder(), r)
+		So(logged.String(), ShouldContainSubstring, `level=WARN msg="handled request" method=greet status=STATUS_PERMISSION_DENIED`)
+		So(logged.String(), ShouldContainSubstring, `request_bytes=0 error="STATUS_PERMISSION_DENIED: nope"`)
+	})
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMetricsPath = "/metrics"
+
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+)
+
+// WithMetrics records request counts, latencies, in-flight requests and body
+// sizes for every route, and serves them in the Prometheus text format at
+// path. If path is empty, /metrics is used. Calls in a batch are recorded
+// against their own routes.
+func WithMetrics(path string) Option {
+	return func(c *TinyRPC) {
+		if path == "" {
+			path = defaultMetricsPath
+		}
+		c.metrics = newMetrics(path)
+	}
+}
+
+type metrics struct {
+	path   string
+	mu     sync.Mutex
+	routes map[string]*routeMetrics
+}
+
+type routeMetrics struct {
+	mu           sync.Mutex
+	inFlight     int64
+	statuses     map[Status]uint64
+	duration     *histogram
+	requestSize  *histogram
+	responseSize *histogram
+}
+
+func newMetrics(path string) *metrics {
+	return &metrics{path: path, routes: map[string]*routeMetrics{}}
+}
+
+// Routes are added up front, so that they're reported before their first call
+func (m *metrics) route(method string) *routeMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	route, ok := m.routes[method]
+	if !ok {
+		route = &routeMetrics{
+			statuses:     map[Status]uint64{},
+			duration:     newHistogram(durationBuckets),
+			requestSize:  newHistogram(sizeBuckets),
+			responseSize: newHistogram(sizeBuckets),
+		}
+		m.routes[method] = route
+	}
+	return route
+}
+
+// Runs just inside tracing, ahead of the header decoding and every other
+// middleware, so that it covers them as well as the handler
+func (m *metrics) middleware(query *RouteContainer) MiddlewareFn {
+	route := m.route(query.qualifiedName())
+	return func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+		start := time.Now()
+		req, body := countBody(req)
+		route.mu.Lock()
+		route.inFlight++
+		route.mu.Unlock()
+
+		return observeCall(ctx, req, handler, func(status Status, res any) {
+			responseBytes, hasResponse := responseSize(ctx, res)
+
+			route.mu.Lock()
+			defer route.mu.Unlock()
+			route.inFlight--
+			route.statuses[status]++
+			route.duration.observe(time.Since(start).Seconds())
+			route.requestSize.observe(float64(body.n))
+			if hasResponse {
+				route.responseSize.observe(float64(responseBytes))
+			}
+		})
+	}
+}
+
+// The size of the response body, which for streams is everything written to
+// the stream. Sockets don't have one.
+func responseSize(ctx context.Context, res any) (int64, bool) {
+	if data, ok := res.([]byte); ok {
+		return int64(len(data)), true
+	}
+	if state := getResponseState(ctx); state != nil && state.stream != nil {
+		return state.stream.bytesWritten(), true
+	}
+	return 0, false
+}
+
+func (m *metrics) handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// Writes the metrics in the Prometheus text exposition format, with the
+// routes in alphabetical order
+func (m *metrics) write(out io.Writer) {
+	m.mu.Lock()
+	methods := make([]string, 0, len(m.routes))
+	routes := make(map[string]*routeMetrics, len(m.routes))
+	for method, route := range m.routes {
+		methods = append(methods, method)
+		routes[method] = route
+	}
+	m.mu.Unlock()
+	sort.Strings(methods)
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	writeHeader(w, "tinyrpc_requests_total", "counter", "Requests handled, by method and status.")
+	for _, method := range methods {
+		route := routes[method]
+		route.mu.Lock()
+		statuses := make([]Status, 0, len(route.statuses))
+		for status := range route.statuses {
+			statuses = append(statuses, status)
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i] < statuses[j] })
+		for _, status := range statuses {
+			fmt.Fprintf(w, "tinyrpc_requests_total{method=%q,status=%q} %d\n", method, status.TSName(), route.statuses[status])
+		}
+		route.mu.Unlock()
+	}
+
+	writeHeader(w, "tinyrpc_requests_in_flight", "gauge", "Requests currently being handled, by method.")
+	for _, method := range methods {
+		route := routes[method]
+		route.mu.Lock()
+		fmt.Fprintf(w, "tinyrpc_requests_in_flight{method=%q} %d\n", method, route.inFlight)
+		route.mu.Unlock()
+	}
+
+	histograms := []struct {
+		name string
+		help string
+		get  func(*routeMetrics) *histogram
+	}{
+		{"tinyrpc_request_duration_seconds", "Time taken to handle requests, by method.", func(r *routeMetrics) *histogram { return r.duration }},
+		{"tinyrpc_request_size_bytes", "Size of request bodies, by method.", func(r *routeMetrics) *histogram { return r.requestSize }},
+		{"tinyrpc_response_size_bytes", "Size of response bodies, by method.", func(r *routeMetrics) *histogram { return r.responseSize }},
+	}
+	for _, h := range histograms {
+		writeHeader(w, h.name, "histogram", h.help)
+		for _, method := range methods {
+			route := routes[method]
+			route.mu.Lock()
+			h.get(route).write(w, h.name, method)
+			route.mu.Unlock()
+		}
+	}
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// A cumulative histogram, as Prometheus expects them
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for idx, bound := range h.buckets {
+		if value <= bound {
+			h.counts[idx]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string, method string) {
+	for idx, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{method=%q,le=%q} %d\n", name, method, formatFloat(bound), h.counts[idx])
+	}
+	fmt.Fprintf(w, "%s_bucket{method=%q,le=\"+Inf\"} %d\n", name, method, h.count)
+	fmt.Fprintf(w, "%s_sum{method=%q} %s\n", name, method, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{method=%q} %d\n", name, method, h.count)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
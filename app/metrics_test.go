@@ -0,0 +1,120 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("metrics are recorded for every route", t, func() {
+		type greetRequest struct {
+			Name string `validate:"nonzero"`
+		}
+		type greetResponse struct{ Greeting string }
+		type countRequest struct{ To int }
+		type countResponse struct{ Current int }
+		type blockRequest struct{}
+		type blockResponse struct{}
+
+		greetFn := func(ctx context.Context, req greetRequest) (*greetResponse, error) {
+			return &greetResponse{Greeting: "hi " + req.Name}, nil
+		}
+		countFn := func(ctx context.Context, req countRequest, send func(*countResponse) error) error {
+			for i := 1; i <= req.To; i++ {
+				if err := send(&countResponse{Current: i}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		started := make(chan struct{})
+		release := make(chan struct{})
+		blockFn := func(ctx context.Context, req blockRequest) (*blockResponse, error) {
+			close(started)
+			<-release
+			return &blockResponse{}, nil
+		}
+
+		a := New("", "", WithMetrics(""), WithBatching(0))
+		NewRoute(greetFn).Attach(a)
+		NewRoute(greetFn).Attach(a.Group("admin"))
+		NewStreamRoute(countFn).Attach(a)
+		NewRoute(blockFn).Attach(a)
+
+		call := func(path string, body string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+		scrape := func() string {
+			r, _ := http.NewRequest("GET", "/metrics", nil)
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+			return w.Body.String()
+		}
+
+		Convey("with requests counted by method and status", func() {
+			call("/tinyrpc/greet", `{"Name": "sam"}`)
+			call("/tinyrpc/greet", `{"Name": "sam"}`)
+			call("/tinyrpc/greet", `{}`)
+			call("/tinyrpc/$batch", `[{"Method": "admin.greet", "Params": {"Name": "a"}}]`)
+
+			out := scrape()
+			So(out, ShouldContainSubstring, "# TYPE tinyrpc_requests_total counter\n")
+			So(out, ShouldContainSubstring, `tinyrpc_requests_total{method="greet",status="STATUS_OK"} 2`+"\n")
+			So(out, ShouldContainSubstring, `tinyrpc_requests_total{method="greet",status="STATUS_INVALID_ARGUMENT"} 1`+"\n")
+			So(out, ShouldContainSubstring, `tinyrpc_requests_total{method="admin.greet",status="STATUS_OK"} 1`+"\n")
+			So(out, ShouldContainSubstring, `tinyrpc_requests_in_flight{method="count"} 0`+"\n")
+		})
+
+		Convey("with latency and size histograms", func() {
+			body := `{"Name": "sam"}`
+			w := call("/tinyrpc/greet", body)
+
+			out := scrape()
+			So(out, ShouldContainSubstring, "# TYPE tinyrpc_request_duration_seconds histogram\n")
+			So(out, ShouldContainSubstring, `tinyrpc_request_duration_seconds_bucket{method="greet",le="10"} 1`+"\n")
+			So(out, ShouldContainSubstring, `tinyrpc_request_duration_seconds_count{method="greet"} 1`+"\n")
+			So(out, ShouldContainSubstring, `tinyrpc_request_size_bytes_bucket{method="greet",le="64"} 1`+"\n")
+			So(out, ShouldContainSubstring, fmt.Sprintf(`tinyrpc_request_size_bytes_sum{method="greet"} %d`+"\n", len(body)))
+			So(out, ShouldContainSubstring, fmt.Sprintf(`tinyrpc_response_size_bytes_sum{method="greet"} %d`+"\n", w.Body.Len()))
+			So(out, ShouldContainSubstring, `tinyrpc_response_size_bytes_bucket{method="greet",le="+Inf"} 1`+"\n")
+		})
+
+		Convey("with everything written to a stream counted as its response", func() {
+			w := call("/tinyrpc/count", `{"To": 3}`)
+			So(scrape(), ShouldContainSubstring, fmt.Sprintf(`tinyrpc_response_size_bytes_sum{method="count"} %d`+"\n", w.Body.Len()))
+		})
+
+		Convey("with requests in flight", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				call("/tinyrpc/block", `{}`)
+			}()
+			<-started
+			So(scrape(), ShouldContainSubstring, `tinyrpc_requests_in_flight{method="block"} 1`+"\n")
+			close(release)
+			<-done
+			So(scrape(), ShouldContainSubstring, `tinyrpc_requests_in_flight{method="block"} 0`+"\n")
+		})
+	})
+
+	Convey("the metrics can be served from another path", t, func() {
+		a := New("", "", WithMetrics("/internal/metrics"))
+		r, _ := http.NewRequest("GET", "/internal/metrics", nil)
+		w := httptest.NewRecorder()
+		a.Handler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldContainSubstring, "# HELP tinyrpc_requests_total")
+	})
+}
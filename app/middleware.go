@@ -25,3 +25,67 @@ func chainFunctions(functions []MiddlewareFn, method string, finalFn MiddlewareH
 		return currentFunction(ctx, req, method, chainFunctions(functions, method, finalFn, current+1))
 	}
 }
+
+// Use registers middleware that wraps every route on the app, including the
+// ones that have already been attached. App middleware runs first, in the
+// order it was registered, followed by any group middleware and then the
+// route's own middleware.
+func (c *TinyRPC) Use(middleware ...MiddlewareFn) {
+	c.prepareMu.Lock()
+	defer c.prepareMu.Unlock()
+	if c.prepared {
+		panic("Middleware must be registered before the app is started")
+	}
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// Wrap the route's handler (which already includes the route's own middleware)
+// with the app middleware and the middleware from the groups it belongs to.
+// The route's header type is decoded before any of it, but the request only
+// fails on bad headers once the app middleware has run. From the outside in,
+// it's tracing, metrics, decodeHeaders, app middleware, checkHeaders, group
+// middleware and then the route's own.
+func (c *TinyRPC) chainMiddleware(query *RouteContainer) MiddlewareHandler {
+	headerType := c.routeHeaderType(query)
+	functions := []MiddlewareFn{}
+	if c.tracing != nil {
+		functions = append(functions, tracingMiddleware(c.tracing, query))
+	}
+	if c.metrics != nil {
+		functions = append(functions, c.metrics.middleware(query))
+	}
+	if headerType != nil {
+		functions = append(functions, decodeHeaders(headerType))
+	}
+	functions = append(functions, c.middleware...)
+	if headerType != nil {
+		functions = append(functions, checkHeaders)
+	}
+	functions = append(functions, query.Group.allMiddleware()...)
+	handleFn := collapseMiddleware(functions, query.FnName, query.HandleFn)
+
+	route := c.newRouteContext(query)
+	return func(ctx context.Context, req any) (any, error) {
+		return handleFn(addRouteToContext(ctx, route), req)
+	}
+}
+
+// Call the handler and then done with the call's status, whether it returned
+// or panicked. Panics carry on up to be turned into INTERNAL errors, so that's
+// the status they get here too.
+func observeCall(ctx context.Context, req any, handler MiddlewareHandler, done func(status Status, res any)) (any, error) {
+	var res any
+	var err error
+	returned := false
+	defer func() {
+		status := STATUS_INTERNAL
+		if returned {
+			status = responseStatus(ctx, err)
+		}
+		done(status, res)
+	}()
+
+	res, err = handler(ctx, req)
+	returned = true
+	return res, err
+}
@@ -40,7 +40,7 @@ func TestMiddleware(t *testing.T) {
 			mwContainer := &middlewareContainer{}
 			rr, err := newRoute.createRouteRep([]MiddlewareFn{mwContainer.Middleware})
 			So(err, ShouldBeNil)
-			handler := buildHandler(rr)
+			handler := New("", "").buildHandler(rr)
 
 			input := getDirContentsRequest{
 				Name: "testname",
@@ -61,7 +61,7 @@ func TestMiddleware(t *testing.T) {
 			mwContainer := &middlewareContainer{}
 			rr, err := newRoute.createRouteRep([]MiddlewareFn{mwContainer.Middleware, mwContainer.Middleware})
 			So(err, ShouldBeNil)
-			handler := buildHandler(rr)
+			handler := New("", "").buildHandler(rr)
 
 			input := getDirContentsRequest{
 				Name: "testname",
@@ -81,7 +81,7 @@ func TestMiddleware(t *testing.T) {
 			mwContainer := &middlewareContainer{}
 			rr, err := newRoute.createRouteRep([]MiddlewareFn{mwContainer.Middleware})
 			So(err, ShouldBeNil)
-			handler := buildHandler(rr)
+			handler := New("", "").buildHandler(rr)
 
 			inputJson := `{ "invalid_key": "invalid_value" }`
 			r, _ := http.NewRequest("POST", "/something", bytes.NewBufferString(inputJson))
@@ -93,3 +93,65 @@ func TestMiddleware(t *testing.T) {
 		})
 	})
 }
+
+func TestAppMiddleware(t *testing.T) {
+	Convey("app middleware wraps every route", t, func() {
+		type getDirContentsRequest struct{}
+		type getDirContentsResponse struct{}
+		type sayHelloRequest struct{}
+		type sayHelloResponse struct{}
+
+		calls := []string{}
+		recordingMiddleware := func(name string) MiddlewareFn {
+			return func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+				calls = append(calls, name+":"+method)
+				return handler(ctx, req)
+			}
+		}
+
+		a := New("", "")
+		// Attached before the app middleware is registered
+		NewRoute(func(ctx context.Context, req getDirContentsRequest) (*getDirContentsResponse, error) {
+			return &getDirContentsResponse{}, nil
+		}).AttachWithMiddleware(a, recordingMiddleware("route"))
+		NewRoute(func(ctx context.Context, req sayHelloRequest) (*sayHelloResponse, error) {
+			return &sayHelloResponse{}, nil
+		}).Attach(a.Group("public", recordingMiddleware("group")))
+
+		a.Use(recordingMiddleware("first"), recordingMiddleware("second"))
+		handler := a.Handler()
+
+		call := func(path string) {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString("{}"))
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+
+		Convey("in a defined order relative to route middleware", func() {
+			call("/tinyrpc/getDirContents")
+			So(calls, ShouldResemble, []string{"first:getDirContents", "second:getDirContents", "route:getDirContents"})
+		})
+
+		Convey("in a defined order relative to group middleware", func() {
+			call("/tinyrpc/public/sayHello")
+			So(calls, ShouldResemble, []string{"first:sayHello", "second:sayHello", "group:sayHello"})
+		})
+
+		Convey("and can't be registered once the app is running", func() {
+			So(func() { a.Use(recordingMiddleware("late")) }, ShouldPanic)
+		})
+
+		Convey("even while the app is being started", func() {
+			b := New("", "")
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				// Either it's registered in time or it panics, without racing
+				defer func() { recover() }()
+				b.Use(recordingMiddleware("late"))
+				b.Group("late").Use(recordingMiddleware("late"))
+			}()
+			b.Handler()
+			<-done
+		})
+	})
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	defaultReadTimeout  = 15 * time.Second
+	defaultWriteTimeout = 15 * time.Second
+)
+
+// Option configures the app, and is passed to New
+type Option func(*TinyRPC)
+
+type serverOptions struct {
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	listener          net.Listener
+	errorLog          *log.Logger
+}
+
+func defaultServerOptions() serverOptions {
+	return serverOptions{
+		readTimeout:  defaultReadTimeout,
+		writeTimeout: defaultWriteTimeout,
+	}
+}
+
+// WithReadTimeout sets the maximum duration for reading the entire request,
+// including the body. Zero means no timeout. Defaults to 15 seconds.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *TinyRPC) {
+		c.serverOpts.readTimeout = timeout
+	}
+}
+
+// WithReadHeaderTimeout sets the amount of time allowed to read the request
+// headers. If it's zero the read timeout is used.
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(c *TinyRPC) {
+		c.serverOpts.readHeaderTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets the maximum duration before timing out writes of the
+// response, which bounds how long a handler can run. Zero means no timeout.
+// Defaults to 15 seconds.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *TinyRPC) {
+		c.serverOpts.writeTimeout = timeout
+	}
+}
+
+// WithIdleTimeout sets how long to wait for the next request on a keep-alive
+// connection. If it's zero the read timeout is used.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *TinyRPC) {
+		c.serverOpts.idleTimeout = timeout
+	}
+}
+
+// WithMaxHeaderBytes limits the size of the request headers. If it's zero
+// http.DefaultMaxHeaderBytes is used.
+func WithMaxHeaderBytes(maxBytes int) Option {
+	return func(c *TinyRPC) {
+		c.serverOpts.maxHeaderBytes = maxBytes
+	}
+}
+
+// WithListener serves the app from an existing listener instead of listening
+// on the host passed to New, e.g. a Unix domain socket:
+//
+//	l, err := net.Listen("unix", "/run/tinyrpc.sock")
+//	a := app.New("localhost", "./output.ts", app.WithListener(l))
+//
+// The host is still used by the generated client.
+func WithListener(listener net.Listener) Option {
+	return func(c *TinyRPC) {
+		c.serverOpts.listener = listener
+	}
+}
+
+// WithHTTPStatusMapping overrides the HTTP status code that's sent for each
+// Status. The default is Status.HTTPStatus.
+func WithHTTPStatusMapping(mapping func(Status) int) Option {
+	return func(c *TinyRPC) {
+		c.httpStatus = mapping
+	}
+}
+
+// WithErrorLog sets the logger the HTTP server uses for errors accepting
+// connections and unexpected behaviour from handlers. Without it these go to
+// the app's logger, see WithLogger.
+func WithErrorLog(logger *log.Logger) Option {
+	return func(c *TinyRPC) {
+		c.serverOpts.errorLog = logger
+	}
+}
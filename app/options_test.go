@@ -0,0 +1,81 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServerOptions(t *testing.T) {
+	Convey("server options are applied to the http server", t, func() {
+		errorLog := log.Default()
+		a := New("localhost:8000", "",
+			WithReadTimeout(time.Minute),
+			WithReadHeaderTimeout(2*time.Second),
+			WithWriteTimeout(0),
+			WithIdleTimeout(time.Hour),
+			WithMaxHeaderBytes(4096),
+			WithErrorLog(errorLog),
+		)
+
+		srv := a.httpServer()
+		So(srv.ReadTimeout, ShouldEqual, time.Minute)
+		So(srv.ReadHeaderTimeout, ShouldEqual, 2*time.Second)
+		So(srv.WriteTimeout, ShouldEqual, 0)
+		So(srv.IdleTimeout, ShouldEqual, time.Hour)
+		So(srv.MaxHeaderBytes, ShouldEqual, 4096)
+		So(srv.ErrorLog, ShouldEqual, errorLog)
+	})
+
+	Convey("timeouts default to 15 seconds", t, func() {
+		srv := New("localhost:8000", "").httpServer()
+		So(srv.ReadTimeout, ShouldEqual, 15*time.Second)
+		So(srv.WriteTimeout, ShouldEqual, 15*time.Second)
+	})
+
+	Convey("app can be served from a unix socket", t, func() {
+		type pingRequest struct{}
+		type pingResponse struct{ Pong bool }
+
+		pingFn := func(ctx context.Context, req pingRequest) (*pingResponse, error) {
+			return &pingResponse{Pong: true}, nil
+		}
+
+		socketPath := filepath.Join(t.TempDir(), "tinyrpc.sock")
+		listener, err := net.Listen("unix", socketPath)
+		So(err, ShouldBeNil)
+
+		a := New("localhost", "", WithListener(listener))
+		NewRoute(pingFn).Attach(a)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- a.Run(ctx)
+		}()
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		}}
+		res, err := client.Post("http://localhost/tinyrpc/ping", "application/json", bytes.NewBufferString("{}"))
+		So(err, ShouldBeNil)
+		defer res.Body.Close()
+
+		var body Res[pingResponse]
+		So(json.NewDecoder(res.Body).Decode(&body), ShouldBeNil)
+		So(body.Body.Pong, ShouldBeTrue)
+
+		cancel()
+		So(<-runErr, ShouldBeNil)
+	})
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RateLimitMode int
+
+const (
+	// Allows bursts of up to Requests calls, refilling evenly over Per
+	TokenBucket RateLimitMode = iota
+	// Allows at most Requests calls in any period of Per
+	SlidingWindow
+)
+
+// RateLimit is how many calls a key can make, e.g. 10 calls per second
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Mode     RateLimitMode
+}
+
+// RateLimitKeyFn picks the key a call is counted against. Calls that get the
+// same key share a limit, including calls with an empty key.
+type RateLimitKeyFn = func(ctx context.Context) string
+
+// ByClientIP counts calls against the address of the client that made them.
+// Behind a proxy that's the proxy's address, in which case use ByHeader with
+// whatever header the proxy sets instead.
+func ByClientIP(ctx context.Context) string {
+	return GetClientIP(ctx)
+}
+
+// ByHeader counts calls against the value of a request header, e.g. an API key
+func ByHeader(name string) RateLimitKeyFn {
+	return func(ctx context.Context) string {
+		return GetHeader(ctx, name)
+	}
+}
+
+// RateLimiter limits how often each key can call the routes it's registered
+// on. Its Middleware method is a MiddlewareFn, so it can be registered with
+// Use on the app or a group, or attached to a single route. Calls over the
+// limit fail with STATUS_RESOURCE_EXHAUSTED, with a Retry-After header and the
+// wait in the error's RetryAfter.
+type RateLimiter struct {
+	key    RateLimitKeyFn
+	limit  RateLimit
+	routes map[string]RateLimit
+
+	mu        sync.Mutex
+	buckets   map[rateLimitBucketKey]rateLimitBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// Routes with their own limit are counted separately from the rest
+type rateLimitBucketKey struct {
+	route string
+	key   string
+}
+
+type rateLimitBucket interface {
+	// Takes a call if there's room for it, otherwise returns how long until
+	// there will be
+	take(now time.Time) (bool, time.Duration)
+	// Whether the bucket is back to how it started, so it can be dropped
+	idle(now time.Time) bool
+}
+
+// NewRateLimiter limits every call to limit per key. If key is nil, calls are
+// counted by client IP.
+func NewRateLimiter(limit RateLimit, key RateLimitKeyFn) *RateLimiter {
+	if limit.Requests <= 0 || limit.Per <= 0 {
+		panic("Rate limits need a positive number of requests and period")
+	}
+	if key == nil {
+		key = ByClientIP
+	}
+	return &RateLimiter{
+		key:     key,
+		limit:   limit,
+		routes:  map[string]RateLimit{},
+		buckets: map[rateLimitBucketKey]rateLimitBucket{},
+		now:     time.Now,
+	}
+}
+
+// WithRouteLimit gives a route its own limit instead of the default one. The
+// route is named the way it's called in a batch, with its group's namespace,
+// e.g. "admin.deleteUser", see RouteInfo.Method.
+func (l *RateLimiter) WithRouteLimit(method string, limit RateLimit) *RateLimiter {
+	if limit.Requests <= 0 || limit.Per <= 0 {
+		panic("Rate limits need a positive number of requests and period")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routes[method] = limit
+	return l
+}
+
+// Middleware is the MiddlewareFn that does the limiting
+func (l *RateLimiter) Middleware(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+	// Routes in different groups can share a name
+	if info, ok := GetRouteInfo(ctx); ok {
+		method = info.Method
+	}
+	if ok, wait := l.take(method, l.key(ctx)); !ok {
+		SetHeader(ctx, "Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return nil, &Error{
+			Status:     STATUS_RESOURCE_EXHAUSTED,
+			Message:    "rate limit exceeded, try again in " + wait.Round(time.Millisecond).String(),
+			RetryAfter: wait,
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (l *RateLimiter) take(method string, key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	limit, ok := l.routes[method]
+	if !ok {
+		limit = l.limit
+		method = ""
+	}
+	l.sweep(now, limit.Per)
+
+	bucketKey := rateLimitBucketKey{route: method, key: key}
+	bucket, ok := l.buckets[bucketKey]
+	if !ok {
+		bucket = newRateLimitBucket(limit, now)
+		l.buckets[bucketKey] = bucket
+	}
+	return bucket.take(now)
+}
+
+// Drop the buckets that have gone back to how they started, so keys that
+// have stopped calling don't hang around forever. It only looks once a period
+// at most, as it has to go through every bucket.
+func (l *RateLimiter) sweep(now time.Time, period time.Duration) {
+	if now.Sub(l.lastSweep) < period {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.idle(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func newRateLimitBucket(limit RateLimit, now time.Time) rateLimitBucket {
+	if limit.Mode == SlidingWindow {
+		return &slidingWindow{limit: limit}
+	}
+	return &tokenBucket{limit: limit, tokens: float64(limit.Requests), last: now}
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	b.last = now
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+elapsed.Seconds()*b.rate())
+}
+
+// Tokens per second
+func (b *tokenBucket) rate() float64 {
+	return float64(b.limit.Requests) / b.limit.Per.Seconds()
+}
+
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate() * float64(time.Second))
+}
+
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Requests)
+}
+
+// Keeps the time of every call in the last period, oldest first
+type slidingWindow struct {
+	limit RateLimit
+	calls []time.Time
+}
+
+func (w *slidingWindow) expire(now time.Time) {
+	idx := 0
+	for idx < len(w.calls) && now.Sub(w.calls[idx]) >= w.limit.Per {
+		idx++
+	}
+	w.calls = w.calls[idx:]
+}
+
+func (w *slidingWindow) take(now time.Time) (bool, time.Duration) {
+	w.expire(now)
+	if len(w.calls) < w.limit.Requests {
+		w.calls = append(w.calls, now)
+		return true, 0
+	}
+	return false, w.calls[0].Add(w.limit.Per).Sub(now)
+}
+
+func (w *slidingWindow) idle(now time.Time) bool {
+	w.expire(now)
+	return len(w.calls) == 0
+}
+
+type tinyRPCClientIPValue struct{}
+
+var tinyRPCClientIPKey = tinyRPCClientIPValue{}
+
+func addClientIPToContext(ctx context.Context, remoteAddr string) context.Context {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	return context.WithValue(ctx, tinyRPCClientIPKey, remoteAddr)
+}
+
+// GetClientIP returns the address the request came from, without its port
+func GetClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(tinyRPCClientIPKey).(string)
+	return ip
+}
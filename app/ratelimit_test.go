@@ -0,0 +1,169 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	type greetRequest struct{}
+	type greetResponse struct{}
+	type searchRequest struct{}
+	type searchResponse struct{}
+
+	greetFn := func(ctx context.Context, req greetRequest) (*greetResponse, error) {
+		return &greetResponse{}, nil
+	}
+	searchFn := func(ctx context.Context, req searchRequest) (*searchResponse, error) {
+		return &searchResponse{}, nil
+	}
+
+	Convey("calls over the limit are rejected", t, func() {
+		now := time.Unix(1000, 0)
+		limiter := NewRateLimiter(RateLimit{Requests: 2, Per: time.Second}, nil)
+		limiter.now = func() time.Time { return now }
+
+		a := New("", "", WithBatching(0))
+		a.Use(limiter.Middleware)
+		NewRoute(greetFn).Attach(a)
+		NewRoute(searchFn).Attach(a)
+		NewRoute(searchFn).Attach(a.Group("admin"))
+
+		call := func(path string, body string, remoteAddr string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			r.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("with a Retry-After header and the wait in the error", func() {
+			So(call("/tinyrpc/greet", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusOK)
+			So(call("/tinyrpc/search", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusOK)
+
+			w := call("/tinyrpc/greet", `{}`, "10.0.0.1:1234")
+			So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "1")
+
+			var res Res[ReturnError]
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			So(res.Status, ShouldEqual, STATUS_RESOURCE_EXHAUSTED)
+			So(res.Body.RetryAfter, ShouldEqual, 500)
+		})
+
+		Convey("with each client counted separately", func() {
+			call("/tinyrpc/greet", `{}`, "10.0.0.1:1234")
+			call("/tinyrpc/greet", `{}`, "10.0.0.1:5678")
+			So(call("/tinyrpc/greet", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusTooManyRequests)
+			So(call("/tinyrpc/greet", `{}`, "10.0.0.2:1234").Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("until the bucket refills", func() {
+			call("/tinyrpc/greet", `{}`, "10.0.0.1:1234")
+			call("/tinyrpc/greet", `{}`, "10.0.0.1:1234")
+			now = now.Add(500 * time.Millisecond)
+			So(call("/tinyrpc/greet", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusOK)
+			So(call("/tinyrpc/greet", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		Convey("with each call in a batch counted", func() {
+			w := call("/tinyrpc/$batch", `[{"Method": "greet", "Params": {}}, {"Method": "greet", "Params": {}}, {"Method": "greet", "Params": {}}]`, "10.0.0.1:1234")
+			var results []Res[ReturnError]
+			So(json.Unmarshal(w.Body.Bytes(), &results), ShouldBeNil)
+			statuses := []Status{results[0].Status, results[1].Status, results[2].Status}
+			So(statuses, ShouldContain, STATUS_OK)
+			So(statuses, ShouldContain, STATUS_RESOURCE_EXHAUSTED)
+		})
+
+		Convey("with routes given their own limits", func() {
+			limiter.WithRouteLimit("search", RateLimit{Requests: 1, Per: time.Minute})
+			So(call("/tinyrpc/search", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusOK)
+			w := call("/tinyrpc/search", `{}`, "10.0.0.1:1234")
+			So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "60")
+
+			// The rest of the routes still have the default limit
+			So(call("/tinyrpc/greet", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusOK)
+			So(call("/tinyrpc/greet", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("with routes in groups named by their namespace", func() {
+			limiter.WithRouteLimit("admin.search", RateLimit{Requests: 1, Per: time.Minute})
+			So(call("/tinyrpc/admin/search", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusOK)
+			So(call("/tinyrpc/admin/search", `{}`, "10.0.0.1:1234").Code, ShouldEqual, http.StatusTooManyRequests)
+
+			// The route with the same name outside the group isn't affected
+			So(call("/tinyrpc/search", `{}`, "10.0.0.2:1234").Code, ShouldEqual, http.StatusOK)
+			So(call("/tinyrpc/search", `{}`, "10.0.0.2:1234").Code, ShouldEqual, http.StatusOK)
+		})
+	})
+
+	Convey("calls can be counted by header", t, func() {
+		limiter := NewRateLimiter(RateLimit{Requests: 1, Per: time.Minute}, ByHeader("X-Api-Key"))
+		a := New("", "")
+		NewRoute(greetFn).AttachWithMiddleware(a, limiter.Middleware)
+
+		call := func(key string) int {
+			r, _ := http.NewRequest("POST", "/tinyrpc/greet", bytes.NewBufferString(`{}`))
+			r.Header.Set("X-Api-Key", key)
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w.Code
+		}
+		So(call("a"), ShouldEqual, http.StatusOK)
+		So(call("a"), ShouldEqual, http.StatusTooManyRequests)
+		So(call("b"), ShouldEqual, http.StatusOK)
+	})
+
+	Convey("a sliding window allows the limit in any period", t, func() {
+		now := time.Unix(1000, 0)
+		limiter := NewRateLimiter(RateLimit{Requests: 2, Per: time.Second, Mode: SlidingWindow}, ByHeader("X-Api-Key"))
+		limiter.now = func() time.Time { return now }
+
+		ok, _ := limiter.take("greet", "a")
+		So(ok, ShouldBeTrue)
+		now = now.Add(600 * time.Millisecond)
+		ok, _ = limiter.take("greet", "a")
+		So(ok, ShouldBeTrue)
+
+		now = now.Add(300 * time.Millisecond)
+		ok, wait := limiter.take("greet", "a")
+		So(ok, ShouldBeFalse)
+		So(wait, ShouldEqual, 100*time.Millisecond)
+
+		now = now.Add(100 * time.Millisecond)
+		ok, _ = limiter.take("greet", "a")
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("buckets are dropped once they're idle", t, func() {
+		now := time.Unix(1000, 0)
+		limiter := NewRateLimiter(RateLimit{Requests: 1, Per: time.Second}, ByHeader("X-Api-Key"))
+		limiter.now = func() time.Time { return now }
+
+		limiter.take("greet", "a")
+		limiter.take("greet", "b")
+		So(limiter.buckets, ShouldHaveLength, 2)
+
+		now = now.Add(2 * time.Second)
+		limiter.take("greet", "c")
+		So(limiter.buckets, ShouldHaveLength, 1)
+	})
+
+	Convey("the client gets the wait on the error", t, func() {
+		a := New("", "")
+		NewRoute(greetFn).Attach(a)
+		code, err := a.genCode()
+		So(err, ShouldBeNil)
+		So(code, ShouldContainSubstring, "RetryAfter?: number;")
+		So(code, ShouldContainSubstring, "RetryAfter: r.Body.RetryAfter")
+		So(code, ShouldContainSubstring, "RetryAfter: retryAfter(res.headers)")
+	})
+}
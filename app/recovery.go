@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicHook is called whenever a handler or middleware panics, e.g. to forward
+// the panic to an error tracker. The request has already been answered with a
+// STATUS_INTERNAL error by the time it's called.
+type PanicHook func(ctx context.Context, method string, recovered any, stack []byte)
+
+// WithPanicHook sets a hook that's called with the recovered value and stack
+// trace when a handler or middleware panics
+func WithPanicHook(hook PanicHook) Option {
+	return func(c *TinyRPC) {
+		c.panicHook = hook
+	}
+}
+
+// The HTTP server's logger, which goes through the app's logger unless one
+// was set with WithErrorLog
+func (c *TinyRPC) errorLog() *log.Logger {
+	if c.serverOpts.errorLog != nil {
+		return c.serverOpts.errorLog
+	}
+	return slog.NewLogLogger(c.logger().Handler(), slog.LevelError)
+}
+
+// Deferred in buildHandler, so that a panic anywhere in the middleware chain
+// or the handler becomes an INTERNAL error instead of a dropped connection
+func (c *TinyRPC) recoverPanic(ctx context.Context, w http.ResponseWriter, query *RouteContainer) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	// net/http uses this to abort the response on purpose, so let it through
+	if recovered == http.ErrAbortHandler {
+		panic(recovered)
+	}
+
+	c.handlePanic(ctx, query, recovered, func(err error) {
+		c.failRequest(w, getResponseState(ctx), err)
+	})
+}
+
+// Log the panic, answer the request through respond and then call the hook.
+// Has to be called from the deferred function so the stack trace includes the
+// panic.
+func (c *TinyRPC) handlePanic(ctx context.Context, query *RouteContainer, recovered any, respond func(error)) {
+	stack := debug.Stack()
+	c.logger().ErrorContext(ctx, "panic handling request",
+		"method", query.FnName,
+		"panic", fmt.Sprint(recovered),
+		"stack", string(stack),
+	)
+
+	respond(NewError(STATUS_INTERNAL, "internal error"))
+
+	if c.panicHook != nil {
+		c.runPanicHook(ctx, query, recovered, stack)
+	}
+}
+
+// The request has already been answered, so a panic in the hook itself is only
+// logged, rather than being left to take down the server
+func (c *TinyRPC) runPanicHook(ctx context.Context, query *RouteContainer, recovered any, stack []byte) {
+	defer func() {
+		if hookRecovered := recover(); hookRecovered != nil {
+			c.logger().ErrorContext(ctx, "panic in panic hook",
+				"method", query.FnName,
+				"panic", fmt.Sprint(hookRecovered),
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+	c.panicHook(ctx, query.FnName, recovered, stack)
+}
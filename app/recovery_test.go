@@ -0,0 +1,80 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	Convey("panics are turned into internal errors", t, func() {
+		type explodeRequest struct{}
+		type explodeResponse struct{}
+
+		explodeFn := func(ctx context.Context, req explodeRequest) (*explodeResponse, error) {
+			panic("boom")
+		}
+
+		var logged bytes.Buffer
+		var hookMethod string
+		var hookRecovered any
+		a := New("", "",
+			WithLogger(slog.New(slog.NewTextHandler(&logged, nil))),
+			WithPanicHook(func(ctx context.Context, method string, recovered any, stack []byte) {
+				hookMethod = method
+				hookRecovered = recovered
+			}),
+		)
+		NewRoute(explodeFn).Attach(a)
+
+		r, _ := http.NewRequest("POST", "/tinyrpc/explode", bytes.NewBufferString("{}"))
+		w := httptest.NewRecorder()
+		a.Handler().ServeHTTP(w, r)
+
+		var body Res[ReturnError]
+		So(json.Unmarshal(w.Body.Bytes(), &body), ShouldBeNil)
+		So(body.Status, ShouldEqual, STATUS_INTERNAL)
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+
+		So(logged.String(), ShouldContainSubstring, `level=ERROR msg="panic handling request" method=explode panic=boom`)
+		So(logged.String(), ShouldContainSubstring, "goroutine")
+		So(hookMethod, ShouldEqual, "explode")
+		So(hookRecovered, ShouldEqual, "boom")
+	})
+
+	Convey("panics in the panic hook are logged too", t, func() {
+		type explodeRequest struct{}
+		type explodeResponse struct{}
+
+		explodeFn := func(ctx context.Context, req explodeRequest) (*explodeResponse, error) {
+			panic("boom")
+		}
+
+		var logged bytes.Buffer
+		a := New("", "", WithBatching(0),
+			WithLogger(slog.New(slog.NewTextHandler(&logged, nil))),
+			WithPanicHook(func(ctx context.Context, method string, recovered any, stack []byte) {
+				panic("tracker is down")
+			}),
+		)
+		NewRoute(explodeFn).Attach(a)
+
+		for _, path := range []string{"/tinyrpc/explode", batchPath} {
+			body := `{}`
+			if path == batchPath {
+				body = `[{"Method": "explode", "Params": {}}]`
+			}
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			So(func() { a.Handler().ServeHTTP(w, r) }, ShouldNotPanic)
+			So(w.Body.String(), ShouldContainSubstring, `"Status":13`)
+		}
+		So(logged.String(), ShouldContainSubstring, `level=ERROR msg="panic in panic hook" method=explode panic="tracker is down"`)
+	})
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Per-request state shared between buildHandler and the route adapter. The
+// middleware in between only passes the context along, so this is how the
+// outcome of the handler makes its way back out to the HTTP response.
+type responseState struct {
+	status Status
+	// Only set for streaming routes
+	stream *streamWriter
+	// Only set for socket routes
+	socket *socketUpgrader
+	// Used instead of the HTTP status mapped from status, if set
+	httpStatus int
+
+	// The headers that will be sent with the response. Guarded by mu, as
+	// handlers may set them from their own goroutines, and left alone once
+	// sent is set.
+	mu     sync.Mutex
+	header http.Header
+	sent   bool
+}
+
+type tinyRPCResponseStateValue struct{}
+
+var tinyRPCResponseStateKey = tinyRPCResponseStateValue{}
+
+// The header is usually the ResponseWriter's own, so that whatever ends up
+// being written, including errors, carries the headers set so far
+func addResponseStateToContext(ctx context.Context, header http.Header) (context.Context, *responseState) {
+	state := &responseState{status: STATUS_OK, header: header}
+	return context.WithValue(ctx, tinyRPCResponseStateKey, state), state
+}
+
+func getResponseState(ctx context.Context) *responseState {
+	state, _ := ctx.Value(tinyRPCResponseStateKey).(*responseState)
+	return state
+}
+
+func setResponseStatus(ctx context.Context, status Status) {
+	if state := getResponseState(ctx); state != nil {
+		state.status = status
+	}
+}
+
+func setResponseHTTPStatus(ctx context.Context, code int) {
+	if state := getResponseState(ctx); state != nil {
+		state.httpStatus = code
+	}
+}
+
+// The status the call ended with. Errors from the route adapter are already
+// in the response, so if there's no error the adapter's status is the one
+// that counts.
+func responseStatus(ctx context.Context, err error) Status {
+	if state := getResponseState(ctx); err == nil && state != nil {
+		return state.status
+	}
+	return StatusFromError(err)
+}
+
+// Run write, which sends the headers, with the lock held. Headers set after
+// that are dropped, as they'd be too late to go out anyway.
+func (s *responseState) sendHeader(write func()) {
+	if s == nil {
+		write()
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = true
+	write()
+}
+
+func updateResponseHeader(ctx context.Context, update func(http.Header)) {
+	state := getResponseState(ctx)
+	if state == nil || state.header == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.sent {
+		update(state.header)
+	}
+}
+
+// SetHeader sets a header on the response, replacing any existing values. It
+// can be called from handlers and middleware, and the headers are sent
+// whether the request succeeds or fails. For streams it has no effect once the
+// first message has been sent, and for sockets once the connection has been
+// upgraded.
+func SetHeader(ctx context.Context, key string, value string) {
+	updateResponseHeader(ctx, func(header http.Header) {
+		header.Set(key, value)
+	})
+}
+
+// AddHeader adds a value to a header on the response, keeping any existing
+// values. See SetHeader.
+func AddHeader(ctx context.Context, key string, value string) {
+	updateResponseHeader(ctx, func(header http.Header) {
+		header.Add(key, value)
+	})
+}
+
+// SetCookie adds a Set-Cookie header to the response. Invalid cookies are
+// silently dropped, the same as http.SetCookie.
+func SetCookie(ctx context.Context, cookie *http.Cookie) {
+	if v := cookie.String(); v != "" {
+		AddHeader(ctx, "Set-Cookie", v)
+	}
+}
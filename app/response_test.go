@@ -0,0 +1,124 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	Convey("handlers and middleware can set response headers", t, func() {
+		type loginRequest struct{ Fail bool }
+		type loginResponse struct{}
+
+		loginFn := func(ctx context.Context, req loginRequest) (*loginResponse, error) {
+			SetHeader(ctx, "Cache-Control", "no-store")
+			if req.Fail {
+				return nil, NewError(STATUS_UNAUTHENTICATED, "wrong password")
+			}
+			SetCookie(ctx, &http.Cookie{Name: "session", Value: "abc", HttpOnly: true})
+			return &loginResponse{}, nil
+		}
+
+		a := New("", "", WithBatching(0))
+		a.Use(func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			AddHeader(ctx, "X-Served-By", "tinyrpc")
+			return handler(ctx, req)
+		})
+		NewRoute(loginFn).Attach(a)
+
+		call := func(path string, body string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("with the headers and cookies sent with the response", func() {
+			w := call("/tinyrpc/login", `{}`)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+			So(w.Header().Get("X-Served-By"), ShouldEqual, "tinyrpc")
+			So(w.Header().Get("Set-Cookie"), ShouldEqual, "session=abc; HttpOnly")
+		})
+
+		Convey("with the headers also sent when the handler fails", func() {
+			w := call("/tinyrpc/login", `{"Fail": true}`)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+			So(w.Header().Get("Set-Cookie"), ShouldEqual, "")
+		})
+
+		Convey("with the headers from each call in a batch kept with its result", func() {
+			w := call("/tinyrpc/$batch", `[{"Method": "login", "Params": {}}, {"Method": "login", "Params": {"Fail": true}}]`)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("X-Served-By"), ShouldBeEmpty)
+
+			var results []batchResult
+			So(json.Unmarshal(w.Body.Bytes(), &results), ShouldBeNil)
+			So(results[0].Headers, ShouldResemble, http.Header{"Cache-Control": {"no-store"}, "X-Served-By": {"tinyrpc"}})
+			So(results[1].Headers, ShouldResemble, http.Header{"Cache-Control": {"no-store"}, "X-Served-By": {"tinyrpc"}})
+			So(results[1].Status, ShouldEqual, STATUS_UNAUTHENTICATED)
+		})
+
+		Convey("but cookies from a batch set on the response", func() {
+			w := call("/tinyrpc/$batch", `[{"Method": "login", "Params": {}}]`)
+			So(w.Header().Values("Set-Cookie"), ShouldResemble, []string{"session=abc; HttpOnly"})
+			So(w.Body.String(), ShouldNotContainSubstring, "session=abc")
+		})
+
+		Convey("and fill in the headers in the client", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "r.Headers = res.headers;")
+			So(code, ShouldContainSubstring, "err.Headers = res.headers;")
+		})
+	})
+
+	Convey("headers set after the response has been sent are ignored", t, func() {
+		type pingRequest struct{}
+		type pingResponse struct{}
+
+		started := make(chan struct{})
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		pingFn := func(ctx context.Context, req pingRequest) (*pingResponse, error) {
+			go func() {
+				defer close(done)
+				SetHeader(ctx, "X-Late", "1")
+				close(started)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						SetHeader(ctx, "X-Late", "1")
+					}
+				}
+			}()
+			<-started
+			return &pingResponse{}, nil
+		}
+
+		a := New("", "")
+		NewRoute(pingFn).Attach(a)
+
+		r, _ := http.NewRequest("POST", "/tinyrpc/ping", bytes.NewBufferString(`{}`))
+		w := httptest.NewRecorder()
+		a.Handler().ServeHTTP(w, r)
+		close(stop)
+		<-done
+
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+	})
+
+	Convey("setting headers outside of a request does nothing", t, func() {
+		So(func() { SetHeader(context.Background(), "X-Test", "1") }, ShouldNotPanic)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // Creates a new query procedure that can be attached to groups / app root.
@@ -16,7 +17,7 @@ func NewRoute[input any, output any](queryFn RouteHandler[input, output]) *Route
 	checkIfQueryStruct(outputType)
 
 	return &Route[input, output]{
-		byteHandler: queryToByteHandlerAdapter(queryFn),
+		handler: queryFn,
 	}
 }
 
@@ -36,42 +37,132 @@ func checkIfQueryStruct[query any](arg query) {
 	}
 }
 
-func extractRouteIOName[inputType any, outputType any]() (string, error) {
-	// Cast the i/o struct names to a string, and pull out the relevant bit
-	inputString := strings.Split(reflect.TypeFor[inputType]().String(), ".")[1]
-	outputString := strings.Split(reflect.TypeFor[outputType]().String(), ".")[1]
+// The name set through Named, or failing that the one taken from the i/o
+// struct names
+func routeName[inputType any, outputType any](explicitName string, inputSuffix string, outputSuffix string) (string, error) {
+	if explicitName == "" {
+		return extractRouteName[inputType, outputType](inputSuffix, outputSuffix)
+	}
+	// Route names end up as Typescript function names and in the URL
+	if !identifierPattern.MatchString(explicitName) {
+		return "", fmt.Errorf("invalid route name %q, route names must be valid Typescript identifiers", explicitName)
+	}
+	return explicitName, nil
+}
+
+// Pull the method name out of the i/o struct names, which should follow the
+// pattern {methodName}{inputSuffix}/{methodName}{outputSuffix}
+func extractRouteName[inputType any, outputType any](inputSuffix string, outputSuffix string) (string, error) {
+	pattern := fmt.Sprintf("{methodName}%s/{methodName}%s", inputSuffix, outputSuffix)
+	inputT := reflect.TypeFor[inputType]()
+	outputT := reflect.TypeFor[outputType]()
 
-	if inputString == outputString {
-		return "", fmt.Errorf("the input and output parameters must have distinct structs")
+	if inputT == outputT {
+		return "", fmt.Errorf("the input and output parameters must have distinct structs to follow the pattern %s, use Named to name the route instead", pattern)
 	}
 
-	inputName := strings.Replace(inputString, "Request", "", 1)
-	outputName := strings.Replace(outputString, "Response", "", 1)
+	inputName, err := trimTypeSuffix(inputT, inputSuffix, pattern)
+	if err != nil {
+		return "", err
+	}
+	outputName, err := trimTypeSuffix(outputT, outputSuffix, pattern)
+	if err != nil {
+		return "", err
+	}
 	if inputName != outputName {
-		return "", fmt.Errorf("input and output structs should match the pattern {methodName}Request/{methodName}Response")
+		return "", fmt.Errorf("input and output structs should match the pattern %s, got %s/%s, use Named to name the route instead", pattern, inputT.Name(), outputT.Name())
 	}
 	return inputName, nil
 }
 
+func trimTypeSuffix(typeOf reflect.Type, suffix string, pattern string) (string, error) {
+	// Anonymous structs, pointers and instantiated generic types (whose names
+	// include their type arguments) can't follow the pattern
+	name := typeOf.Name()
+	if name == "" || strings.Contains(name, "[") {
+		return "", fmt.Errorf("can't take the route name from %s as it doesn't follow the pattern %s, use Named to name the route instead", typeOf, pattern)
+	}
+	if !strings.HasSuffix(name, suffix) || name == suffix {
+		return "", fmt.Errorf("%s doesn't follow the pattern %s, use Named to name the route instead", name, pattern)
+	}
+	return strings.TrimSuffix(name, suffix), nil
+}
+
+// Named sets the route's name, rather than taking it from the i/o struct
+// names. This means the structs can be called anything, and can be shared
+// with other routes.
+func (p *Route[input, output]) Named(name string) *Route[input, output] {
+	p.name = name
+	return p
+}
+
+// WithHeaderType sets the struct the route's request headers are decoded
+// into, in place of the group's or app's. See TinyRPC.AddHeaderType.
+func (p *Route[input, output]) WithHeaderType(header any) *Route[input, output] {
+	p.header = header
+	return p
+}
+
+// WithMaxBodyBytes sets the largest request body the route accepts, in place
+// of the app's limit. Less than zero means no limit.
+func (p *Route[input, output]) WithMaxBodyBytes(maxBytes int64) *Route[input, output] {
+	p.maxBodyBytes = maxBytes
+	return p
+}
+
+// WithTimeout sets how long the handler has to respond. Its context is
+// cancelled once the timeout passes, and the client gets
+// STATUS_DEADLINE_EXCEEDED. A shorter timeout sent by the client takes
+// precedence.
+func (p *Route[input, output]) WithTimeout(timeout time.Duration) *Route[input, output] {
+	p.timeout = timeout
+	return p
+}
+
 func (p *Route[input, output]) createRouteRep(interceptors []MiddlewareFn) (*RouteContainer, error) {
-	inputName, err := extractRouteIOName[input, output]()
+	// A handler that matches the shape of the generic function but deals in
+	// bytes that are unmarshalled/ marshalled from/ to json
+	byteHandler := queryToByteHandlerAdapter(interceptHandler(p.handler, p.interceptors))
+	rr, err := buildRouteContainer[input, output](p.name, byteHandler, interceptors)
+	if err != nil {
+		return nil, err
+	}
+	rr.MaxBodyBytes = p.maxBodyBytes
+	rr.Timeout = p.timeout
+	return rr, setRouteHeaderType(rr, p.header)
+}
 
+// Build the RouteContainer for a unary or streaming route
+func buildRouteContainer[input any, output any](name string, byteHandler MiddlewareHandler, interceptors []MiddlewareFn) (*RouteContainer, error) {
+	inputName, err := routeName[input, output](name, "Request", "Response")
 	if err != nil {
 		return nil, err
 	}
+	return newRouteContainer[input, output](inputName, byteHandler, interceptors), nil
+}
+
+func setRouteHeaderType(rr *RouteContainer, header any) error {
+	if header == nil {
+		return nil
+	}
+	headerType, err := resolveHeaderType(header)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rr.FnName, err)
+	}
+	rr.HeaderType = headerType
+	return nil
+}
 
-	queryPath := fmt.Sprintf("/tinyrpc/%s", inputName)
+func newRouteContainer[input any, output any](inputName string, byteHandler MiddlewareHandler, interceptors []MiddlewareFn) *RouteContainer {
 	if interceptors == nil {
 		interceptors = []MiddlewareFn{}
 	}
 
-	chainedInterceptors := collapseMiddleware(interceptors, inputName, p.byteHandler)
-
 	return &RouteContainer{
 		InputType:  reflect.TypeFor[input](),
 		OutputType: reflect.TypeFor[output](),
 		FnName:     inputName,
-		HandleFn:   chainedInterceptors,
-		QueryPath:  queryPath,
-	}, nil
+		HandleFn:   collapseMiddleware(interceptors, inputName, byteHandler),
+		QueryPath:  fmt.Sprintf("/tinyrpc/%s", inputName),
+	}
 }
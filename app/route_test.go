@@ -0,0 +1,104 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type page[T any] struct {
+	Items []T
+	Next  string
+}
+
+type pagedUser struct{ Name string }
+
+func TestRouteNaming(t *testing.T) {
+	Convey("routes can be named explicitly", t, func() {
+		type userFilter struct{ Name string }
+		type okResponse struct{ OK bool }
+
+		filterFn := func(ctx context.Context, req userFilter) (*okResponse, error) {
+			return &okResponse{OK: true}, nil
+		}
+		listFn := func(ctx context.Context, req userFilter) (*page[pagedUser], error) {
+			return &page[pagedUser]{Items: []pagedUser{{Name: req.Name}}}, nil
+		}
+
+		a := New("", "")
+		NewRoute(filterFn).Named("deleteUsers").Attach(a)
+		NewRoute(filterFn).Named("archiveUsers").Attach(a.Group("admin"))
+		NewRoute(listFn).Named("listUsers").Attach(a)
+
+		Convey("with the name used for the path", func() {
+			r, _ := http.NewRequest("POST", "/tinyrpc/admin/archiveUsers", bytes.NewBufferString(`{}`))
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, `{"Body":{"OK":true},"Status":0}`)
+		})
+
+		Convey("with shared and generic types generated once", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export async function deleteUsers(params: userFilter, headers?: HeadersInit, options?: CallOptions): Promise<Response<okResponse> | Error> {")
+			So(code, ShouldContainSubstring, "export async function archiveUsers(params: userFilter, headers?: HeadersInit, options?: CallOptions): Promise<Response<okResponse> | Error> {")
+			So(code, ShouldContainSubstring, "export async function listUsers(params: userFilter, headers?: HeadersInit, options?: CallOptions): Promise<Response<page_pagedUser> | Error> {")
+			So(code, ShouldContainSubstring, "export interface page_pagedUser {")
+			So(code, ShouldContainSubstring, "Items?: pagedUser[];")
+			So(bytes.Count([]byte(code), []byte("export interface okResponse {")), ShouldEqual, 1)
+		})
+	})
+
+	Convey("routes that can't be named return clear errors", t, func() {
+		type userFilter struct{}
+		type getUserRequest struct{}
+		type getUserResponse struct{}
+		type getAccountResponse struct{}
+
+		a := New("", "")
+
+		err := NewRoute(func(ctx context.Context, req userFilter) (*getUserResponse, error) {
+			return nil, nil
+		}).Register(a)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "userFilter doesn't follow the pattern {methodName}Request/{methodName}Response, use Named to name the route instead")
+
+		err = NewRoute(func(ctx context.Context, req getUserRequest) (*getAccountResponse, error) {
+			return nil, nil
+		}).Register(a)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "got getUserRequest/getAccountResponse")
+
+		err = NewRoute(func(ctx context.Context, req getUserRequest) (*page[pagedUser], error) {
+			return nil, nil
+		}).Register(a)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "can't take the route name from app.page[")
+
+		err = NewRoute(func(ctx context.Context, req getUserRequest) (*getUserResponse, error) {
+			return nil, nil
+		}).Named("get-user").Register(a)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "invalid route name")
+
+		So(NewRoute(func(ctx context.Context, req getUserRequest) (*getUserResponse, error) {
+			return nil, nil
+		}).Register(a), ShouldBeNil)
+		err = NewRoute(func(ctx context.Context, req userFilter) (*getUserResponse, error) {
+			return nil, nil
+		}).Named("getUser").Register(a)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "Duplicate handler")
+
+		So(func() {
+			NewRoute(func(ctx context.Context, req userFilter) (*getUserResponse, error) {
+				return nil, nil
+			}).Attach(a)
+		}, ShouldPanic)
+	})
+}
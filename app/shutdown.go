@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultShutdownTimeout = 15 * time.Second
+
+var errServerShuttingDown = NewError(STATUS_UNAVAILABLE, "server is shutting down")
+
+// How often Shutdown checks whether the in-flight requests have drained
+const shutdownPollInterval = 50 * time.Millisecond
+
+// ActiveRequest describes a request that is currently being handled
+type ActiveRequest struct {
+	Method  string
+	Path    string
+	Started time.Time
+}
+
+// ShutdownError is returned by Shutdown when the context expires before all of
+// the in-flight requests have finished. Active holds the requests that were
+// still running at that point.
+type ShutdownError struct {
+	Err    error
+	Active []ActiveRequest
+}
+
+func (e *ShutdownError) Error() string {
+	running := make([]string, len(e.Active))
+	for idx, req := range e.Active {
+		running[idx] = fmt.Sprintf("%s (running for %v)", req.Path, time.Since(req.Started).Round(time.Millisecond))
+	}
+	return fmt.Sprintf("shutdown: %v, %d request(s) still running: [%s]", e.Err, len(e.Active), strings.Join(running, ", "))
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+type activeRequests struct {
+	mu       sync.Mutex
+	nextID   uint64
+	requests map[uint64]ActiveRequest
+}
+
+func (a *activeRequests) add(req ActiveRequest) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.requests == nil {
+		a.requests = make(map[uint64]ActiveRequest)
+	}
+	a.nextID++
+	a.requests[a.nextID] = req
+	return a.nextID
+}
+
+func (a *activeRequests) remove(id uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.requests, id)
+}
+
+func (a *activeRequests) list() []ActiveRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]ActiveRequest, 0, len(a.requests))
+	for _, req := range a.requests {
+		list = append(list, req)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Started.Before(list[j].Started)
+	})
+	return list
+}
+
+// Wrap a route handler so that it's visible to Shutdown while it's running
+func (c *TinyRPC) trackActive(query *RouteContainer, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := c.active.add(ActiveRequest{
+			Method:  query.FnName,
+			Path:    req.URL.Path,
+			Started: time.Now(),
+		})
+		defer c.active.remove(id)
+
+		next(w, req)
+	}
+}
+
+// ActiveRequests returns the requests that are currently being handled, oldest
+// first
+func (c *TinyRPC) ActiveRequests() []ActiveRequest {
+	return c.active.list()
+}
+
+// OnShutdown registers a hook that's run by Shutdown once the server has
+// stopped accepting requests. Hooks are run in the order they were registered,
+// and receive the same context as Shutdown.
+func (c *TinyRPC) OnShutdown(hook func(ctx context.Context) error) {
+	c.shutdownHooks = append(c.shutdownHooks, hook)
+}
+
+// SetShutdownTimeout sets how long Run waits for in-flight requests to finish
+// once its context has been cancelled
+func (c *TinyRPC) SetShutdownTimeout(timeout time.Duration) {
+	c.shutdownTimeout = timeout
+}
+
+// Shutdown stops the server from accepting new requests, cancels any open
+// streams and sockets, waits for the in-flight requests to finish and then
+// runs the shutdown hooks. If ctx expires first, the returned error is a
+// *ShutdownError listing the requests that were still running.
+func (c *TinyRPC) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	c.stopLongLived(errServerShuttingDown)
+	err := c.httpServer().Shutdown(ctx)
+	if err == nil {
+		err = c.waitForActive(ctx)
+	}
+	if err != nil {
+		errs = append(errs, &ShutdownError{Err: err, Active: c.ActiveRequests()})
+	}
+
+	for _, hook := range c.shutdownHooks {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// The HTTP server already waits for its own connections, but this also covers
+// requests that arrived through some other listener
+func (c *TinyRPC) waitForActive(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for len(c.ActiveRequests()) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Run starts the server and blocks until ctx is cancelled, at which point it
+// shuts the server down, giving in-flight requests up to the shutdown timeout
+// to complete.
+func (c *TinyRPC) Run(ctx context.Context) error {
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- c.Start()
+	}()
+
+	select {
+	case err := <-startErr:
+		// The server stopped by itself, so there's nothing to shut down
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
+
+	shutdownErr := c.Shutdown(shutdownCtx)
+	return errors.Join(shutdownErr, <-startErr)
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("shutdown waits for in-flight requests", t, func() {
+		type slowRequest struct{}
+		type slowResponse struct{}
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		slowFn := func(ctx context.Context, req slowRequest) (*slowResponse, error) {
+			close(started)
+			<-release
+			return &slowResponse{}, nil
+		}
+
+		a := New("127.0.0.1:0", "")
+		NewRoute(slowFn).Attach(a)
+		a.assembleHandlers()
+
+		hookCalled := false
+		a.OnShutdown(func(ctx context.Context) error {
+			hookCalled = true
+			return nil
+		})
+
+		done := make(chan struct{})
+		go func() {
+			r, _ := http.NewRequest("POST", "/tinyrpc/slow", bytes.NewBufferString("{}"))
+			a.router.ServeHTTP(httptest.NewRecorder(), r)
+			close(done)
+		}()
+		<-started
+
+		Convey("and reports the requests still running at the deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			err := a.Shutdown(ctx)
+			So(err, ShouldNotBeNil)
+
+			var shutdownErr *ShutdownError
+			So(errors.As(err, &shutdownErr), ShouldBeTrue)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			So(shutdownErr.Active, ShouldHaveLength, 1)
+			So(shutdownErr.Active[0].Method, ShouldEqual, "slow")
+			So(hookCalled, ShouldBeTrue)
+
+			close(release)
+			<-done
+		})
+
+		Convey("and returns cleanly once they complete", func() {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				close(release)
+			}()
+
+			err := a.Shutdown(context.Background())
+			So(err, ShouldBeNil)
+			So(a.ActiveRequests(), ShouldBeEmpty)
+			So(hookCalled, ShouldBeTrue)
+			<-done
+		})
+	})
+
+	Convey("start returns nil after shutdown", t, func() {
+		a := New("127.0.0.1:0", "")
+		So(a.Shutdown(context.Background()), ShouldBeNil)
+		So(a.Start(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,308 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SocketHandler handles a socket route. It's called once the connection has
+// been upgraded, and the connection is closed when it returns. Returning an
+// error sends it to the client as the last message before closing.
+type SocketHandler[input any, output any] func(ctx context.Context, conn *SocketConn[input, output]) error
+
+// SocketRoute is a route backed by a WebSocket, where the client and the
+// server can both send messages for as long as the connection is open
+type SocketRoute[input any, output any] struct {
+	byteHandler  func(context.Context, any) (any, error)
+	name         string
+	header       any
+	maxBodyBytes int64
+}
+
+// SocketConn is the server's end of a socket route's connection. Receive
+// should only be called from one goroutine at a time, Send is safe to call
+// from several.
+type SocketConn[input any, output any] struct {
+	ctx context.Context
+	ws  *wsConn
+}
+
+// Creates a new socket procedure. The message structs should match the pattern
+// {methodName}ClientMessage/{methodName}ServerMessage, for messages sent by the
+// client and the server respectively. The generated client function returns a
+// SocketConnection, which reconnects if the connection drops.
+func NewSocketRoute[input any, output any](socketFn SocketHandler[input, output]) *SocketRoute[input, output] {
+	var inputType input
+	checkIfQueryStruct(inputType)
+
+	var outputType output
+	checkIfQueryStruct(outputType)
+
+	return &SocketRoute[input, output]{
+		byteHandler: socketToByteHandlerAdapter(socketFn),
+	}
+}
+
+// Named sets the route's name, rather than taking it from the message struct
+// names
+func (p *SocketRoute[input, output]) Named(name string) *SocketRoute[input, output] {
+	p.name = name
+	return p
+}
+
+// WithHeaderType sets the struct the route's request headers are decoded
+// into, in place of the group's or app's
+func (p *SocketRoute[input, output]) WithHeaderType(header any) *SocketRoute[input, output] {
+	p.header = header
+	return p
+}
+
+// WithMaxBodyBytes sets the largest message the client can send, in place of
+// the app's WithMaxBodyBytes
+func (p *SocketRoute[input, output]) WithMaxBodyBytes(maxBytes int64) *SocketRoute[input, output] {
+	p.maxBodyBytes = maxBytes
+	return p
+}
+
+func (p *SocketRoute[input, output]) createRouteRep(interceptors []MiddlewareFn) (*RouteContainer, error) {
+	inputName, err := routeName[input, output](p.name, "ClientMessage", "ServerMessage")
+	if err != nil {
+		return nil, err
+	}
+	rr := newRouteContainer[input, output](inputName, p.byteHandler, interceptors)
+	rr.Kind = RouteSocket
+	rr.MaxBodyBytes = p.maxBodyBytes
+	return rr, setRouteHeaderType(rr, p.header)
+}
+
+// Attach the route to the app or a Group, with middleware that only applies
+// to this route. Middleware runs once, before the connection is upgraded, and
+// is passed an empty body.
+func (p *SocketRoute[input, output]) AttachWithMiddleware(target RouteTarget, headerMiddleware ...MiddlewareFn) {
+	if err := p.Register(target, headerMiddleware...); err != nil {
+		panic(err)
+	}
+}
+
+// Register is the same as AttachWithMiddleware, but returns an error rather
+// than panicking if the route can't be attached
+func (p *SocketRoute[input, output]) Register(target RouteTarget, headerMiddleware ...MiddlewareFn) error {
+	rr, err := p.createRouteRep(headerMiddleware)
+	if err != nil {
+		return err
+	}
+	return target.addRoute(rr)
+}
+
+// Just an alias for AttachWithMiddleware
+func (p *SocketRoute[input, output]) Attach(target RouteTarget) {
+	p.AttachWithMiddleware(target)
+}
+
+// Receive waits for the next message from the client. Messages that can't be
+// decoded or fail validation return an *Error, after which the connection can
+// still be used. Once the client has closed the connection it returns io.EOF.
+func (s *SocketConn[input, output]) Receive() (*input, error) {
+	data, err := s.ws.readMessage()
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return nil, context.Cause(s.ctx)
+		}
+		return nil, err
+	}
+
+	var msg input
+	err = json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, NewError(STATUS_INVALID_ARGUMENT, err.Error())
+	}
+
+	err = validateRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Send a message to the client
+func (s *SocketConn[input, output]) Send(msg *output) error {
+	if err := s.ctx.Err(); err != nil {
+		return context.Cause(s.ctx)
+	}
+	data, err := writeResponse(Res[*output]{Status: STATUS_OK, Body: msg})
+	if err != nil {
+		return err
+	}
+	return s.ws.writeText(data)
+}
+
+// SendError sends an error to the client without closing the connection, e.g.
+// to reject a message that failed validation
+func (s *SocketConn[input, output]) SendError(err error) error {
+	data, marshalErr := buildHandlerError(err)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return s.ws.writeText(data)
+}
+
+// The socket equivalent of queryToByteHandlerAdapter. Once the connection has
+// been upgraded the response goes over the socket, so nothing is returned.
+// Errors before then are returned as a normal response.
+func socketToByteHandlerAdapter[inputType any, outputType any](socketFunc SocketHandler[inputType, outputType]) func(context.Context, any) (any, error) {
+	return func(ctx context.Context, _ any) (any, error) {
+		fail := func(err error) (any, error) {
+			setResponseStatus(ctx, StatusFromError(err))
+			return buildHandlerError(err)
+		}
+
+		state := getResponseState(ctx)
+		if state == nil || state.socket == nil {
+			return fail(NewError(STATUS_INTERNAL, "socket route called without a connection"))
+		}
+
+		ws, err := state.socket.upgrade()
+		if err != nil {
+			return fail(err)
+		}
+
+		// Receive would otherwise block until the client goes away
+		stop := context.AfterFunc(ctx, func() {
+			ws.closeWithError(socketCancelCause(ctx))
+		})
+		defer stop()
+
+		err = socketFunc(ctx, &SocketConn[inputType, outputType]{ctx: ctx, ws: ws})
+		if err != nil && ctx.Err() != nil {
+			err = socketCancelCause(ctx)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			setResponseStatus(ctx, StatusFromError(err))
+			ws.closeWithError(err)
+			return nil, nil
+		}
+
+		setResponseStatus(ctx, STATUS_OK)
+		ws.close(wsCloseNormal, "")
+		return nil, nil
+	}
+}
+
+// Why the socket's context was cancelled, e.g. the server shutting down, as
+// an error that can be sent to the client
+func socketCancelCause(ctx context.Context) error {
+	var rpcErr *Error
+	if cause := context.Cause(ctx); errors.As(cause, &rpcErr) {
+		return cause
+	}
+	return NewError(STATUS_CANCELLED, "connection cancelled")
+}
+
+// Upgrades the request once the middleware has run. Until then, errors can
+// still be sent as a normal response.
+type socketUpgrader struct {
+	w               http.ResponseWriter
+	req             *http.Request
+	state           *responseState
+	maxMessageBytes int64
+	conn            *wsConn
+}
+
+func newSocketUpgrader(w http.ResponseWriter, req *http.Request, state *responseState, maxMessageBytes int64) *socketUpgrader {
+	return &socketUpgrader{w: w, req: req, state: state, maxMessageBytes: maxMessageBytes}
+}
+
+func (s *socketUpgrader) upgrade() (*wsConn, error) {
+	if s.conn != nil {
+		return nil, NewError(STATUS_INTERNAL, "connection already upgraded")
+	}
+	var conn *wsConn
+	var err error
+	s.state.sendHeader(func() {
+		conn, err = upgradeWebSocket(s.w, s.req, s.maxMessageBytes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+func (s *socketUpgrader) upgraded() bool {
+	return s.conn != nil
+}
+
+// Browsers don't apply CORS or the same-origin policy to WebSockets, so any
+// page could otherwise open a socket with the user's cookies. They do send the
+// page's origin though, and only the app's own origin, and those allowed by
+// WithCORS, can connect. Clients that aren't browsers don't send an origin,
+// and are let through.
+func (c *TinyRPC) checkSocketOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := req.Header.Get("Origin")
+		if origin != "" && !isSameOrigin(req, origin) && (c.cors == nil || !c.cors.allowsOrigin(origin)) {
+			c.logger().Debug("refused socket from another origin", "path", req.URL.Path, "origin", origin)
+			c.writeError(w, Errorf(STATUS_PERMISSION_DENIED, "origin %s isn't allowed", origin))
+			return
+		}
+		next(w, req)
+	}
+}
+
+func isSameOrigin(req *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// WithSocketQueryHeaders lets socket routes take these headers from query
+// parameters, on top of the fields of the route's header type. Anything in a
+// URL tends to end up in access logs, so be careful with tokens.
+func WithSocketQueryHeaders(names ...string) Option {
+	return func(c *TinyRPC) {
+		for _, name := range names {
+			c.socketQueryHeaders = append(c.socketQueryHeaders, http.CanonicalHeaderKey(name))
+		}
+	}
+}
+
+// The headers a socket route takes from query parameters, which are the ones
+// the generated client sends, plus any from WithSocketQueryHeaders. Any other
+// parameter would let a URL set headers the route doesn't expect.
+func (c *TinyRPC) socketQueryHeaderNames(query *RouteContainer) map[string]bool {
+	names := map[string]bool{}
+	if headerType := c.routeHeaderType(query); headerType != nil {
+		for _, field := range headerFields(headerType) {
+			names[http.CanonicalHeaderKey(headerFieldName(field))] = true
+		}
+	}
+	if c.tracing != nil {
+		names[http.CanonicalHeaderKey(traceparentHeader)] = true
+	}
+	for _, name := range c.socketQueryHeaders {
+		names[name] = true
+	}
+	return names
+}
+
+// Query parameters are only used where there isn't already a header with the
+// same name
+func mergeQueryIntoHeaders(req *http.Request, allowed map[string]bool) http.Header {
+	headers := req.Header.Clone()
+	query, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return headers
+	}
+	for key, values := range query {
+		key = http.CanonicalHeaderKey(key)
+		if _, ok := headers[key]; !ok && allowed[key] {
+			headers[key] = values
+		}
+	}
+	return headers
+}
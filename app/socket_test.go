@@ -0,0 +1,486 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// Just enough of a WebSocket client to talk to socket routes
+type testSocketClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestSocket(srv *httptest.Server, path string, headers http.Header) (*testSocketClient, *http.Response, error) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &testSocketClient{conn: conn, reader: reader}, res, nil
+}
+
+func (c *testSocketClient) writeFrame(fin bool, opcode byte, payload []byte) error {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for idx, b := range payload {
+		frame = append(frame, b^mask[idx%4])
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *testSocketClient) send(msg string) error {
+	return c.writeFrame(true, wsOpText, []byte(msg))
+}
+
+func (c *testSocketClient) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(c.reader, payload)
+	return header[0] & 0x0F, payload, err
+}
+
+func (c *testSocketClient) receive() (string, error) {
+	opcode, payload, err := c.readFrame()
+	if err != nil {
+		return "", err
+	}
+	if opcode != wsOpText {
+		return "", fmt.Errorf("expected a text frame, got opcode %d", opcode)
+	}
+	return string(payload), nil
+}
+
+// Read up to the close frame, returning its code
+func (c *testSocketClient) closeCode() (int, error) {
+	code, _, err := c.closeFrame()
+	return code, err
+}
+
+// Read up to the close frame, returning its code and reason
+func (c *testSocketClient) closeFrame() (int, string, error) {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, "", err
+		}
+		if opcode == wsOpClose {
+			return int(binary.BigEndian.Uint16(payload)), string(payload[2:]), nil
+		}
+	}
+}
+
+func TestSocketRoute(t *testing.T) {
+	Convey("socket routes exchange typed messages", t, func() {
+		type echoClientMessage struct {
+			Text string `validate:"nonzero"`
+		}
+		type echoServerMessage struct {
+			Text  string
+			Token string
+		}
+
+		echoFn := func(ctx context.Context, conn *SocketConn[echoClientMessage, echoServerMessage]) error {
+			for {
+				msg, err := conn.Receive()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					if StatusFromError(err) == STATUS_INVALID_ARGUMENT {
+						conn.SendError(err)
+						continue
+					}
+					return err
+				}
+				if msg.Text == "stop" {
+					return NewError(STATUS_ABORTED, "asked to stop")
+				}
+				if msg.Text == "stop at length" {
+					return NewError(STATUS_ABORTED, strings.Repeat("ü", 100))
+				}
+				err = conn.Send(&echoServerMessage{Text: msg.Text, Token: GetHeader(ctx, "token")})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		a := New("", "", WithSocketQueryHeaders("token"))
+		NewSocketRoute(echoFn).Attach(a)
+		srv := httptest.NewServer(a.Handler())
+		defer srv.Close()
+
+		client, res, err := dialTestSocket(srv, "/tinyrpc/echo?token=abc&x-admin=true", nil)
+		So(err, ShouldBeNil)
+		defer client.conn.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+		So(res.Header.Get("Sec-WebSocket-Accept"), ShouldEqual, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+
+		Convey("with query parameters available as headers", func() {
+			So(client.send(`{"Text": "hello"}`), ShouldBeNil)
+			msg, err := client.receive()
+			So(err, ShouldBeNil)
+			So(msg, ShouldEqual, `{"Body":{"Text":"hello","Token":"abc"},"Status":0}`)
+		})
+
+		Convey("with fragmented messages put back together", func() {
+			So(client.writeFrame(false, wsOpText, []byte(`{"Text": `)), ShouldBeNil)
+			So(client.writeFrame(true, wsOpPing, []byte("ping")), ShouldBeNil)
+			So(client.writeFrame(true, wsOpContinuation, []byte(`"pieces"}`)), ShouldBeNil)
+
+			opcode, payload, err := client.readFrame()
+			So(err, ShouldBeNil)
+			So(opcode, ShouldEqual, wsOpPong)
+			So(string(payload), ShouldEqual, "ping")
+
+			msg, err := client.receive()
+			So(err, ShouldBeNil)
+			So(msg, ShouldContainSubstring, `"Text":"pieces"`)
+		})
+
+		Convey("with invalid messages rejected without closing the connection", func() {
+			So(client.send(`{"Text": ""}`), ShouldBeNil)
+			msg, err := client.receive()
+			So(err, ShouldBeNil)
+			So(msg, ShouldContainSubstring, `"Status":3`)
+
+			So(client.send(`{"Text": "still here"}`), ShouldBeNil)
+			msg, err = client.receive()
+			So(err, ShouldBeNil)
+			So(msg, ShouldContainSubstring, `"Text":"still here"`)
+		})
+
+		Convey("with handler errors sent before closing with the status", func() {
+			So(client.send(`{"Text": "stop"}`), ShouldBeNil)
+			msg, err := client.receive()
+			So(err, ShouldBeNil)
+			So(msg, ShouldEqual, `{"Body":{"ErrorMessage":"asked to stop"},"Status":10}`)
+
+			code, err := client.closeCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 4000+int(STATUS_ABORTED))
+		})
+
+		Convey("with characters split across fragments put back together", func() {
+			So(client.writeFrame(false, wsOpText, []byte("{\"Text\": \"caf\xc3")), ShouldBeNil)
+			So(client.writeFrame(true, wsOpContinuation, []byte("\xa9\"}")), ShouldBeNil)
+			msg, err := client.receive()
+			So(err, ShouldBeNil)
+			So(msg, ShouldContainSubstring, `"Text":"café"`)
+		})
+
+		Convey("with text that isn't UTF-8 refused", func() {
+			So(client.send("{\"Text\": \"\xff\"}"), ShouldBeNil)
+			code, err := client.closeCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, wsCloseInvalidPayload)
+		})
+
+		Convey("with control frames over 125 bytes refused", func() {
+			So(client.writeFrame(true, wsOpPing, bytes.Repeat([]byte("a"), 126)), ShouldBeNil)
+			code, err := client.closeCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, wsCloseProtocolError)
+		})
+
+		Convey("with fragmented control frames refused", func() {
+			So(client.writeFrame(false, wsOpPing, []byte("ping")), ShouldBeNil)
+			code, err := client.closeCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, wsCloseProtocolError)
+		})
+
+		Convey("with long close reasons cut without splitting characters", func() {
+			So(client.send(`{"Text": "stop at length"}`), ShouldBeNil)
+			_, err := client.receive()
+			So(err, ShouldBeNil)
+
+			code, reason, err := client.closeFrame()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 4000+int(STATUS_ABORTED))
+			So(utf8.ValidString(reason), ShouldBeTrue)
+			So(reason, ShouldEqual, strings.Repeat("ü", 61))
+		})
+
+		Convey("with the close handshake answered", func() {
+			So(client.writeFrame(true, wsOpClose, binary.BigEndian.AppendUint16(nil, 1000)), ShouldBeNil)
+			code, err := client.closeCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 1000)
+		})
+
+		Convey("and generate a connection in the client", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export function echo(headers?: HeadersInit, reconnect?: boolean): SocketConnection<echoClientMessage, echoServerMessage> {")
+			So(code, ShouldContainSubstring, `return new SocketConnection<echoClientMessage, echoServerMessage>(socketURL("/tinyrpc/echo", headers), reconnect);`)
+			So(code, ShouldContainSubstring, "export class SocketConnection<Send, Receive> {")
+		})
+	})
+
+	Convey("socket middleware runs before the upgrade", t, func() {
+		type lockedClientMessage struct{}
+		type lockedServerMessage struct{}
+
+		lockedFn := func(ctx context.Context, conn *SocketConn[lockedClientMessage, lockedServerMessage]) error {
+			return nil
+		}
+		denyAll := func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			return nil, NewError(STATUS_UNAUTHENTICATED, "no entry")
+		}
+
+		a := New("", "")
+		NewSocketRoute(lockedFn).AttachWithMiddleware(a, denyAll)
+		srv := httptest.NewServer(a.Handler())
+		defer srv.Close()
+
+		client, res, err := dialTestSocket(srv, "/tinyrpc/locked", nil)
+		So(err, ShouldBeNil)
+		defer client.conn.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+	})
+
+	Convey("socket messages are limited like request bodies", t, func() {
+		type sinkClientMessage struct {
+			Text string
+		}
+		type sinkServerMessage struct{}
+
+		sinkFn := func(ctx context.Context, conn *SocketConn[sinkClientMessage, sinkServerMessage]) error {
+			for {
+				if _, err := conn.Receive(); err != nil {
+					return err
+				}
+			}
+		}
+		message := `{"Text": "` + strings.Repeat("a", 200) + `"}`
+
+		Convey("by the app's limit", func() {
+			a := New("", "", WithMaxBodyBytes(100))
+			NewSocketRoute(sinkFn).Attach(a)
+			srv := httptest.NewServer(a.Handler())
+			defer srv.Close()
+
+			client, _, err := dialTestSocket(srv, "/tinyrpc/sink", nil)
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(client.send(message), ShouldBeNil)
+			code, err := client.closeCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, wsCloseMessageTooBig)
+		})
+
+		Convey("or the route's own", func() {
+			a := New("", "", WithMaxBodyBytes(100))
+			NewSocketRoute(sinkFn).WithMaxBodyBytes(1000).Attach(a)
+			srv := httptest.NewServer(a.Handler())
+			defer srv.Close()
+
+			client, _, err := dialTestSocket(srv, "/tinyrpc/sink", nil)
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(client.send(message), ShouldBeNil)
+			So(client.writeFrame(true, wsOpPing, []byte("ping")), ShouldBeNil)
+			opcode, _, err := client.readFrame()
+			So(err, ShouldBeNil)
+			So(opcode, ShouldEqual, wsOpPong)
+		})
+
+		Convey("across fragments too", func() {
+			a := New("", "")
+			NewSocketRoute(sinkFn).WithMaxBodyBytes(100).Attach(a)
+			srv := httptest.NewServer(a.Handler())
+			defer srv.Close()
+
+			client, _, err := dialTestSocket(srv, "/tinyrpc/sink", nil)
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(client.writeFrame(false, wsOpText, []byte(message[:90])), ShouldBeNil)
+			So(client.writeFrame(true, wsOpContinuation, []byte(message[90:])), ShouldBeNil)
+			code, err := client.closeCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, wsCloseMessageTooBig)
+		})
+	})
+
+	Convey("sockets only take declared headers from query parameters", t, func() {
+		type lockedClientMessage struct{}
+		type lockedServerMessage struct{}
+		type authHeaders struct {
+			Authorization string `json:"Authorization"`
+		}
+
+		lockedFn := func(ctx context.Context, conn *SocketConn[lockedClientMessage, lockedServerMessage]) error {
+			return nil
+		}
+		checkAuth := func(ctx context.Context, req any, method string, handler MiddlewareHandler) (any, error) {
+			if GetHeader(ctx, "Authorization") != "abc" {
+				return nil, NewError(STATUS_UNAUTHENTICATED, "no entry")
+			}
+			if GetHeader(ctx, "X-Admin") != "" {
+				return nil, NewError(STATUS_PERMISSION_DENIED, "not an admin")
+			}
+			return handler(ctx, req)
+		}
+
+		a := New("", "")
+		NewSocketRoute(lockedFn).WithHeaderType(authHeaders{}).AttachWithMiddleware(a, checkAuth)
+		srv := httptest.NewServer(a.Handler())
+		defer srv.Close()
+
+		Convey("with the fields of the header type", func() {
+			client, res, err := dialTestSocket(srv, "/tinyrpc/locked?Authorization=abc", nil)
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+		})
+
+		Convey("but not anything else", func() {
+			client, res, err := dialTestSocket(srv, "/tinyrpc/locked?Authorization=abc&x-admin=true", nil)
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+		})
+
+		Convey("which still comes through as a real header", func() {
+			client, res, err := dialTestSocket(srv, "/tinyrpc/locked?Authorization=abc", http.Header{"X-Admin": {"true"}})
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+	})
+
+	Convey("sockets can't be opened from other origins", t, func() {
+		type lockedClientMessage struct{}
+		type lockedServerMessage struct{}
+
+		lockedFn := func(ctx context.Context, conn *SocketConn[lockedClientMessage, lockedServerMessage]) error {
+			return nil
+		}
+
+		a := New("", "")
+		NewSocketRoute(lockedFn).Attach(a)
+		srv := httptest.NewServer(a.Handler())
+		defer srv.Close()
+
+		Convey("with a foreign origin refused", func() {
+			client, res, err := dialTestSocket(srv, "/tinyrpc/locked", http.Header{"Origin": {"http://evil.example"}})
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("but the app's own origin allowed", func() {
+			client, res, err := dialTestSocket(srv, "/tinyrpc/locked", http.Header{"Origin": {srv.URL}})
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+		})
+
+		Convey("as well as clients that don't send one", func() {
+			client, res, err := dialTestSocket(srv, "/tinyrpc/locked", nil)
+			So(err, ShouldBeNil)
+			defer client.conn.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+		})
+	})
+
+	Convey("open sockets are closed when the app shuts down", t, func() {
+		type waitClientMessage struct{}
+		type waitServerMessage struct{}
+
+		waitFn := func(ctx context.Context, conn *SocketConn[waitClientMessage, waitServerMessage]) error {
+			if err := conn.Send(&waitServerMessage{}); err != nil {
+				return err
+			}
+			_, err := conn.Receive()
+			return err
+		}
+
+		a := New("", "")
+		NewSocketRoute(waitFn).Attach(a)
+		srv := httptest.NewServer(a.Handler())
+		defer srv.Close()
+
+		client, _, err := dialTestSocket(srv, "/tinyrpc/wait", nil)
+		So(err, ShouldBeNil)
+		defer client.conn.Close()
+
+		// Wait for the first message so we know the socket is open
+		_, err = client.receive()
+		So(err, ShouldBeNil)
+
+		So(a.Shutdown(context.Background()), ShouldBeNil)
+
+		msg, err := client.receive()
+		So(err, ShouldBeNil)
+		So(msg, ShouldEqual, `{"Body":{"ErrorMessage":"server is shutting down"},"Status":14}`)
+		code, err := client.closeCode()
+		So(err, ShouldBeNil)
+		So(code, ShouldEqual, 4000+int(STATUS_UNAVAILABLE))
+	})
+
+	Convey("socket message structs follow their own naming convention", t, func() {
+		type chatRequest struct{}
+		type chatResponse struct{}
+
+		_, err := NewSocketRoute(func(ctx context.Context, conn *SocketConn[chatRequest, chatResponse]) error {
+			return nil
+		}).createRouteRep(nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "{methodName}ClientMessage/{methodName}ServerMessage")
+	})
+}
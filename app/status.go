@@ -3,6 +3,8 @@ package app
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"time"
 )
 
 type Status int
@@ -88,7 +90,101 @@ func (s Status) TSName() string {
 	}
 }
 
+// HTTPStatus maps the status to the equivalent HTTP status code, following
+// the same mapping as grpc-gateway
+func (s Status) HTTPStatus() int {
+	switch s {
+	case STATUS_OK:
+		return http.StatusOK
+	case STATUS_CANCELLED:
+		// Not a standard code, but it's what nginx and grpc-gateway use for
+		// the client closing the request
+		return 499
+	case STATUS_UNKNOWN:
+		return http.StatusInternalServerError
+	case STATUS_INVALID_ARGUMENT:
+		return http.StatusBadRequest
+	case STATUS_DEADLINE_EXCEEDED:
+		return http.StatusGatewayTimeout
+	case STATUS_NOT_FOUND:
+		return http.StatusNotFound
+	case STATUS_ALREADY_EXISTS:
+		return http.StatusConflict
+	case STATUS_PERMISSION_DENIED:
+		return http.StatusForbidden
+	case STATUS_RESOURCE_EXHAUSTED:
+		return http.StatusTooManyRequests
+	case STATUS_FAILED_PRECONDITION:
+		return http.StatusBadRequest
+	case STATUS_ABORTED:
+		return http.StatusConflict
+	case STATUS_OUT_OF_RANGE:
+		return http.StatusBadRequest
+	case STATUS_UNIMPLEMENTED:
+		return http.StatusNotImplemented
+	case STATUS_INTERNAL:
+		return http.StatusInternalServerError
+	case STATUS_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	case STATUS_DATA_LOSS:
+		return http.StatusInternalServerError
+	case STATUS_UNAUTHENTICATED:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is an error that carries a Status through to the client. It can be
+// returned from handlers and middleware, either directly or wrapped, and the
+// status, message and any details end up in the Res[ReturnError] that's sent
+// back.
+type Error struct {
+	Status  Status
+	Message string
+	// Optional extra information for the client, marshalled to JSON
+	Details any
+	// Optional list of the fields in the request that were invalid
+	Violations []FieldViolation
+	// Optional hint for how long the client should wait before trying again
+	RetryAfter time.Duration
+
+	cause error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status.TSName(), e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
 func NewError(s Status, msg string) error {
-	errorString := fmt.Sprintf("%s: %s", s.TSName(), msg)
-	return errors.New(errorString)
+	return &Error{Status: s, Message: msg}
+}
+
+// NewErrorWithDetails is NewError, but with additional details for the client
+func NewErrorWithDetails(s Status, msg string, details any) error {
+	return &Error{Status: s, Message: msg, Details: details}
+}
+
+// Errorf builds the message with fmt.Errorf, so errors wrapped with %w, any
+// number of them, can still be found with errors.Is/ errors.As
+func Errorf(s Status, format string, args ...any) error {
+	wrapped := fmt.Errorf(format, args...)
+	return &Error{Status: s, Message: wrapped.Error(), cause: wrapped}
+}
+
+// StatusFromError returns the status of the first *Error in err's chain.
+// Other errors are treated as STATUS_INTERNAL, and a nil error is STATUS_OK.
+func StatusFromError(err error) Status {
+	if err == nil {
+		return STATUS_OK
+	}
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Status
+	}
+	return STATUS_INTERNAL
 }
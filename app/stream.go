@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StreamHandler handles a streaming route. Each call to send pushes a message
+// to the client, and the stream ends when the handler returns. Returning an
+// error before anything has been sent is the same as a regular route failing,
+// after that the client receives it as the last message on the stream.
+type StreamHandler[input any, output any] func(ctx context.Context, query input, send func(*output) error) error
+
+// StreamRoute is a route that pushes a series of messages to the client as
+// server-sent events, rather than returning a single response
+type StreamRoute[input any, output any] struct {
+	byteHandler  func(context.Context, any) (any, error)
+	name         string
+	header       any
+	maxBodyBytes int64
+	timeout      time.Duration
+}
+
+// Creates a new streaming procedure, following the same naming convention as
+// NewRoute. The generated client function returns an AsyncIterable of the
+// output type.
+func NewStreamRoute[input any, output any](streamFn StreamHandler[input, output]) *StreamRoute[input, output] {
+	var inputType input
+	checkIfQueryStruct(inputType)
+
+	var outputType output
+	checkIfQueryStruct(outputType)
+
+	return &StreamRoute[input, output]{
+		byteHandler: streamToByteHandlerAdapter(streamFn),
+	}
+}
+
+// Named sets the route's name, rather than taking it from the i/o struct
+// names
+func (p *StreamRoute[input, output]) Named(name string) *StreamRoute[input, output] {
+	p.name = name
+	return p
+}
+
+// WithHeaderType sets the struct the route's request headers are decoded
+// into, in place of the group's or app's
+func (p *StreamRoute[input, output]) WithHeaderType(header any) *StreamRoute[input, output] {
+	p.header = header
+	return p
+}
+
+// WithMaxBodyBytes sets the largest request body the route accepts, in place
+// of the app's limit. Less than zero means no limit.
+func (p *StreamRoute[input, output]) WithMaxBodyBytes(maxBytes int64) *StreamRoute[input, output] {
+	p.maxBodyBytes = maxBytes
+	return p
+}
+
+// WithTimeout limits how long the whole stream can run for, after which it
+// ends with STATUS_DEADLINE_EXCEEDED. A shorter timeout sent by the client
+// takes precedence.
+func (p *StreamRoute[input, output]) WithTimeout(timeout time.Duration) *StreamRoute[input, output] {
+	p.timeout = timeout
+	return p
+}
+
+func (p *StreamRoute[input, output]) createRouteRep(interceptors []MiddlewareFn) (*RouteContainer, error) {
+	rr, err := buildRouteContainer[input, output](p.name, p.byteHandler, interceptors)
+	if err != nil {
+		return nil, err
+	}
+	rr.Kind = RouteStream
+	rr.MaxBodyBytes = p.maxBodyBytes
+	rr.Timeout = p.timeout
+	return rr, setRouteHeaderType(rr, p.header)
+}
+
+// Attach the route to the app or a Group, with middleware that only applies
+// to this route. Middleware runs once, before the stream starts.
+func (p *StreamRoute[input, output]) AttachWithMiddleware(target RouteTarget, headerMiddleware ...MiddlewareFn) {
+	if err := p.Register(target, headerMiddleware...); err != nil {
+		panic(err)
+	}
+}
+
+// Register is the same as AttachWithMiddleware, but returns an error rather
+// than panicking if the route can't be attached
+func (p *StreamRoute[input, output]) Register(target RouteTarget, headerMiddleware ...MiddlewareFn) error {
+	rr, err := p.createRouteRep(headerMiddleware)
+	if err != nil {
+		return err
+	}
+	return target.addRoute(rr)
+}
+
+// Just an alias for AttachWithMiddleware
+func (p *StreamRoute[input, output]) Attach(target RouteTarget) {
+	p.AttachWithMiddleware(target)
+}
+
+// The streaming equivalent of queryToByteHandlerAdapter. Messages are written
+// straight to the client, so if the stream has started nothing is returned.
+// Otherwise the error response is returned as normal.
+func streamToByteHandlerAdapter[inputType any, outputType any](streamFunc StreamHandler[inputType, outputType]) func(context.Context, any) (any, error) {
+	return func(ctx context.Context, input any) (any, error) {
+		fail := func(err error) (any, error) {
+			setResponseStatus(ctx, StatusFromError(err))
+			return buildHandlerError(err)
+		}
+
+		state := getResponseState(ctx)
+		if state == nil || state.stream == nil {
+			return fail(NewError(STATUS_INTERNAL, "streaming route called without a stream"))
+		}
+		stream := state.stream
+
+		var body inputType
+		err := decodeBody(ctx, input, &body)
+		if err != nil {
+			return fail(err)
+		}
+
+		err = validateRequest(body)
+		if err != nil {
+			return fail(err)
+		}
+
+		send := func(msg *outputType) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			data, err := writeResponse(Res[*outputType]{Status: STATUS_OK, Body: msg})
+			if err != nil {
+				return err
+			}
+			return stream.writeEvent(streamEventMessage, data)
+		}
+
+		err = streamFunc(ctx, body, send)
+		if err != nil {
+			err = cancelledError(ctx, err)
+		}
+		if err != nil {
+			if !stream.isStarted() {
+				return fail(err)
+			}
+			setResponseStatus(ctx, StatusFromError(err))
+			stream.sendError(err)
+			return nil, nil
+		}
+
+		setResponseStatus(ctx, STATUS_OK)
+		stream.writeEvent(streamEventEnd, []byte("{}"))
+		return nil, nil
+	}
+}
+
+const (
+	streamEventMessage = "message"
+	streamEventError   = "error"
+	streamEventEnd     = "end"
+)
+
+// Writes server-sent events to the client. Nothing is written until the first
+// event, so that errors before then can still be sent as a normal response.
+type streamWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	state   *responseState
+	started bool
+	written int64
+}
+
+func newStreamWriter(w http.ResponseWriter, state *responseState) *streamWriter {
+	return &streamWriter{w: w, state: state}
+}
+
+func (s *streamWriter) isStarted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started
+}
+
+// Must be called with the lock held
+func (s *streamWriter) start() {
+	s.started = true
+
+	rc := http.NewResponseController(s.w)
+	// The server's write timeout is meant for unary responses, streams can
+	// legitimately stay open for much longer
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	s.state.sendHeader(func() {
+		headers := s.w.Header()
+		headers.Set("Content-Type", "text/event-stream")
+		headers.Set("Cache-Control", "no-cache")
+		// Stop nginx and similar from buffering the events
+		headers.Set("X-Accel-Buffering", "no")
+		s.w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (s *streamWriter) writeEvent(event string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		s.start()
+	}
+
+	// json.Marshal doesn't emit newlines, so the data always fits on one line
+	n, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	s.written += int64(n)
+	if err != nil {
+		return err
+	}
+	return http.NewResponseController(s.w).Flush()
+}
+
+func (s *streamWriter) bytesWritten() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written
+}
+
+func (s *streamWriter) sendError(err error) {
+	data, marshalErr := buildHandlerError(err)
+	if marshalErr != nil {
+		data, _ = buildError(STATUS_INTERNAL, marshalErr.Error())
+	}
+	s.writeEvent(streamEventError, data)
+}
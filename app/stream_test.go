@@ -0,0 +1,114 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStreamRoute(t *testing.T) {
+	Convey("stream routes send server-sent events", t, func() {
+		type countRequest struct {
+			To     int `validate:"min=1"`
+			FailAt int
+		}
+		type countResponse struct{ Current int }
+
+		countFn := func(ctx context.Context, req countRequest, send func(*countResponse) error) error {
+			for i := 1; i <= req.To; i++ {
+				if i == req.FailAt {
+					return NewError(STATUS_ABORTED, "stopped counting")
+				}
+				if err := send(&countResponse{Current: i}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		a := New("", "")
+		NewStreamRoute(countFn).Attach(a)
+		srv := httptest.NewServer(a.Handler())
+		defer srv.Close()
+
+		call := func(body string) (*http.Response, string) {
+			res, err := http.Post(srv.URL+"/tinyrpc/count", "application/json", bytes.NewBufferString(body))
+			So(err, ShouldBeNil)
+			defer res.Body.Close()
+			out, err := io.ReadAll(res.Body)
+			So(err, ShouldBeNil)
+			return res, string(out)
+		}
+
+		Convey("with a message per send and an end event", func() {
+			res, body := call(`{"To": 2}`)
+			So(res.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+			So(body, ShouldEqual, ""+
+				"event: message\ndata: {\"Body\":{\"Current\":1},\"Status\":0}\n\n"+
+				"event: message\ndata: {\"Body\":{\"Current\":2},\"Status\":0}\n\n"+
+				"event: end\ndata: {}\n\n")
+		})
+
+		Convey("with errors after the stream starts sent as an error event", func() {
+			_, body := call(`{"To": 3, "FailAt": 2}`)
+			So(body, ShouldStartWith, "event: message\ndata: {\"Body\":{\"Current\":1},\"Status\":0}\n\n")
+			So(body, ShouldEndWith, "event: error\ndata: {\"Body\":{\"ErrorMessage\":\"stopped counting\"},\"Status\":10}\n\n")
+		})
+
+		Convey("with errors before the stream starts sent as a normal response", func() {
+			res, body := call(`{"To": 0}`)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(res.Header.Get("Content-Type"), ShouldEqual, "application/json")
+
+			var errRes Res[ReturnError]
+			So(json.Unmarshal([]byte(body), &errRes), ShouldBeNil)
+			So(errRes.Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
+		})
+
+		Convey("and generate an async generator in the client", func() {
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export function count(params: countRequest, headers?: HeadersInit, options?: CallOptions): AsyncGenerator<countResponse, void, undefined> {")
+			So(code, ShouldContainSubstring, "async function* streamFunc<T, K>(")
+		})
+	})
+
+	Convey("open streams are ended when the app shuts down", t, func() {
+		type tailRequest struct{}
+		type tailResponse struct{ Line string }
+
+		tailFn := func(ctx context.Context, req tailRequest, send func(*tailResponse) error) error {
+			if err := send(&tailResponse{Line: "first"}); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		a := New("", "")
+		NewStreamRoute(tailFn).Attach(a)
+		srv := httptest.NewServer(a.Handler())
+		defer srv.Close()
+
+		res, err := http.Post(srv.URL+"/tinyrpc/tail", "application/json", bytes.NewBufferString("{}"))
+		So(err, ShouldBeNil)
+		defer res.Body.Close()
+
+		// Wait for the first message so we know the stream is open
+		firstEvent := make([]byte, len("event: message\n"))
+		_, err = io.ReadFull(res.Body, firstEvent)
+		So(err, ShouldBeNil)
+
+		So(a.Shutdown(context.Background()), ShouldBeNil)
+
+		rest, err := io.ReadAll(res.Body)
+		So(err, ShouldBeNil)
+		So(string(rest), ShouldEndWith, "event: error\ndata: {\"Body\":{\"ErrorMessage\":\"server is shutting down\"},\"Status\":14}\n\n")
+	})
+}
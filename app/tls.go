@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+)
+
+type tlsSettings struct {
+	certFile string
+	keyFile  string
+	config   *tls.Config
+	// Set through RequireClientCerts or VerifyClientCertIfGiven, and applied
+	// on top of config when the server starts
+	clientAuth tls.ClientAuthType
+	clientCAs  *x509.CertPool
+}
+
+func (t *tlsSettings) enabled() bool {
+	return t.config != nil || t.certFile != "" || t.clientAuth != tls.NoClientCert
+}
+
+// Put the config together when the server starts, so the various TLS setters
+// can be called in any order
+func (t *tlsSettings) tlsConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.config != nil {
+		config = t.config.Clone()
+	}
+	if t.clientAuth != tls.NoClientCert {
+		config.ClientAuth = t.clientAuth
+		config.ClientCAs = t.clientCAs
+	}
+	return config
+}
+
+// SetTLS serves the app over HTTPS using the given certificate and key files
+func (c *TinyRPC) SetTLS(certFile string, keyFile string) {
+	c.tls.certFile = certFile
+	c.tls.keyFile = keyFile
+}
+
+// SetTLSConfig serves the app over HTTPS using the given config. If the config
+// doesn't carry any certificates, they need to be provided through SetTLS.
+// RequireClientCerts and VerifyClientCertIfGiven take precedence over the
+// config's ClientAuth and ClientCAs, whichever order they're called in.
+func (c *TinyRPC) SetTLSConfig(config *tls.Config) {
+	if config == nil {
+		panic("TLS config can't be nil")
+	}
+	c.tls.config = config.Clone()
+}
+
+// RequireClientCerts enables mutual TLS: clients have to present a certificate
+// signed by one of the CAs in the pool, which can then be retrieved from the
+// context with GetPeerCertificate
+func (c *TinyRPC) RequireClientCerts(clientCAs *x509.CertPool) {
+	c.tls.clientAuth = tls.RequireAndVerifyClientCert
+	c.tls.clientCAs = clientCAs
+}
+
+// VerifyClientCertIfGiven is like RequireClientCerts, except clients can
+// leave out the certificate, in which case GetPeerCertificate returns nil.
+// Certificates that are given still have to be signed by one of the CAs.
+func (c *TinyRPC) VerifyClientCertIfGiven(clientCAs *x509.CertPool) {
+	c.tls.clientAuth = tls.VerifyClientCertIfGiven
+	c.tls.clientCAs = clientCAs
+}
+
+type tinyRPCPeerCertificateValue struct{}
+
+var tinyRPCPeerCertificateKey = tinyRPCPeerCertificateValue{}
+
+// Only verified certificates make it into the context, so handlers can trust
+// whatever comes back from GetPeerCertificate
+func addPeerCertificateToContext(ctx context.Context, state *tls.ConnectionState) context.Context {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, tinyRPCPeerCertificateKey, state.VerifiedChains[0][0])
+}
+
+// GetPeerCertificate returns the verified client certificate for the current
+// request, or nil if the client didn't present one
+func GetPeerCertificate(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(tinyRPCPeerCertificateKey).(*x509.Certificate)
+	return cert
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	So(err, ShouldBeNil)
+	cert, err := x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	Convey("verified client certificates are available to handlers", t, func() {
+		caCert, caKey := newTestCertificate(&x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "test ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+		}, nil, nil)
+		clientCert, clientKey := newTestCertificate(&x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "test client"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caCert, caKey)
+
+		type whoAmIRequest struct{}
+		type whoAmIResponse struct{ CommonName string }
+
+		whoAmIFn := func(ctx context.Context, req whoAmIRequest) (*whoAmIResponse, error) {
+			cert := GetPeerCertificate(ctx)
+			if cert == nil {
+				return &whoAmIResponse{}, nil
+			}
+			return &whoAmIResponse{CommonName: cert.Subject.CommonName}, nil
+		}
+
+		pool := x509.NewCertPool()
+		pool.AddCert(caCert)
+
+		serve := func(a *TinyRPC) *httptest.Server {
+			NewRoute(whoAmIFn).Attach(a)
+			srv := httptest.NewUnstartedServer(a.Handler())
+			srv.TLS = a.tls.tlsConfig()
+			srv.StartTLS()
+			return srv
+		}
+		call := func(srv *httptest.Server, withCert bool) (string, error) {
+			transport := srv.Client().Transport.(*http.Transport).Clone()
+			client := &http.Client{Transport: transport}
+			if withCert {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{{
+					Certificate: [][]byte{clientCert.Raw},
+					PrivateKey:  clientKey,
+				}}
+			}
+			res, err := client.Post(srv.URL+"/tinyrpc/whoAmI", "application/json", bytes.NewBufferString("{}"))
+			if err != nil {
+				return "", err
+			}
+			defer res.Body.Close()
+
+			var body Res[whoAmIResponse]
+			So(json.NewDecoder(res.Body).Decode(&body), ShouldBeNil)
+			return body.Body.CommonName, nil
+		}
+
+		Convey("when they're required", func() {
+			a := New("", "")
+			a.RequireClientCerts(pool)
+			srv := serve(a)
+			defer srv.Close()
+
+			name, err := call(srv, true)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "test client")
+
+			_, err = call(srv, false)
+			So(err, ShouldNotBeNil)
+
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, `const host = "https://`)
+		})
+
+		Convey("along with a TLS config, whichever is set first", func() {
+			before := New("", "")
+			before.RequireClientCerts(pool)
+			before.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS13})
+			after := New("", "")
+			after.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS13})
+			after.RequireClientCerts(pool)
+
+			for _, a := range []*TinyRPC{before, after} {
+				config := a.tls.tlsConfig()
+				So(config.MinVersion, ShouldEqual, tls.VersionTLS13)
+				So(config.ClientAuth, ShouldEqual, tls.RequireAndVerifyClientCert)
+				So(config.ClientCAs, ShouldEqual, pool)
+
+				srv := serve(a)
+				defer srv.Close()
+				name, err := call(srv, true)
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, "test client")
+				_, err = call(srv, false)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("when they're optional", func() {
+			a := New("", "")
+			a.VerifyClientCertIfGiven(pool)
+			srv := serve(a)
+			defer srv.Close()
+
+			name, err := call(srv, true)
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "test client")
+
+			name, err = call(srv, false)
+			So(err, ShouldBeNil)
+			So(name, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,275 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The W3C Trace Context header, see https://www.w3.org/TR/trace-context/
+const traceparentHeader = "traceparent"
+
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanData is a finished span, as it's handed to the exporter
+type SpanData struct {
+	Name    string
+	TraceID TraceID
+	SpanID  SpanID
+	// Zero if the span has no parent
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Status       Status
+	Attributes   map[string]any
+}
+
+// SpanExporter receives spans once they've ended, e.g. to send them on to a
+// tracing backend. ExportSpan is called from the request's goroutine, so it
+// shouldn't block.
+type SpanExporter interface {
+	ExportSpan(span SpanData)
+}
+
+// WithTracing starts a span for every call, named after the route, which is
+// handed to the exporter once the call has finished. A traceparent header sent
+// with the request makes the span part of the caller's trace. The generated
+// client gains setTracing, and a traceparent option for individual calls.
+func WithTracing(exporter SpanExporter) Option {
+	return func(c *TinyRPC) {
+		c.tracing = exporter
+	}
+}
+
+// Span is a span that's in progress. Its methods can be called on a nil Span,
+// which is what StartSpan returns when the request isn't being traced.
+type Span struct {
+	mu       sync.Mutex
+	data     SpanData
+	sampled  bool
+	exporter SpanExporter
+	ended    bool
+}
+
+type tinyRPCSpanValue struct{}
+
+var tinyRPCSpanKey = tinyRPCSpanValue{}
+
+// SpanFromContext returns the current span, or nil if there isn't one
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(tinyRPCSpanKey).(*Span)
+	return span
+}
+
+// StartSpan starts a child of the current span, e.g. around a database call.
+// End has to be called once it's finished. If the request isn't being traced
+// it returns a nil Span, which is safe to use.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		data: SpanData{
+			Name:         name,
+			TraceID:      parent.data.TraceID,
+			SpanID:       newSpanID(),
+			ParentSpanID: parent.data.SpanID,
+			Start:        time.Now(),
+			Status:       STATUS_OK,
+			Attributes:   map[string]any{},
+		},
+		sampled:  parent.sampled,
+		exporter: parent.exporter,
+	}
+	return context.WithValue(ctx, tinyRPCSpanKey, span), span
+}
+
+// TraceParent returns the traceparent header for the current span, so that
+// the trace can be carried on to other services. It's empty if the request
+// isn't being traced.
+func TraceParent(ctx context.Context) string {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	flags := "00"
+	if span.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", span.data.TraceID, span.data.SpanID, flags)
+}
+
+func (s *Span) TraceID() TraceID {
+	if s == nil {
+		return TraceID{}
+	}
+	return s.data.TraceID
+}
+
+func (s *Span) SpanID() SpanID {
+	if s == nil {
+		return SpanID{}
+	}
+	return s.data.SpanID
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+func (s *Span) SetStatus(status Status) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = status
+}
+
+// End finishes the span and exports it, if the trace is sampled. Only the
+// first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]any, len(s.data.Attributes))
+	for key, value := range s.data.Attributes {
+		data.Attributes[key] = value
+	}
+	s.mu.Unlock()
+
+	if s.sampled {
+		s.exporter.ExportSpan(data)
+	}
+}
+
+// Runs first in the chain, so that the span covers all of the middleware. The
+// caller's trace is picked up from the traceparent header, otherwise the span
+// starts a new one.
+func tracingMiddleware(exporter SpanExporter, query *RouteContainer) MiddlewareFn {
+	method := query.qualifiedName()
+	return func(ctx context.Context, req any, _ string, handler MiddlewareHandler) (any, error) {
+		span := &Span{
+			data: SpanData{
+				Name:       query.FnName,
+				SpanID:     newSpanID(),
+				Start:      time.Now(),
+				Status:     STATUS_OK,
+				Attributes: map[string]any{"tinyrpc.method": method},
+			},
+			sampled:  true,
+			exporter: exporter,
+		}
+		if traceID, parentID, sampled, ok := parseTraceParent(GetHeader(ctx, traceparentHeader)); ok {
+			span.data.TraceID = traceID
+			span.data.ParentSpanID = parentID
+			span.sampled = sampled
+		} else {
+			span.data.TraceID = newTraceID()
+		}
+		ctx = context.WithValue(ctx, tinyRPCSpanKey, span)
+
+		req, body := countBody(req)
+
+		return observeCall(ctx, req, handler, func(status Status, res any) {
+			span.SetStatus(status)
+			span.SetAttribute("tinyrpc.status", status.TSName())
+			span.SetAttribute("tinyrpc.request_bytes", body.n)
+			if responseBytes, ok := responseSize(ctx, res); ok {
+				span.SetAttribute("tinyrpc.response_bytes", responseBytes)
+			}
+			span.End()
+		})
+	}
+}
+
+// Parses a version 00 traceparent, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceParent(value string) (TraceID, SpanID, bool, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return TraceID{}, SpanID{}, false, false
+	}
+	var traceID TraceID
+	var parentID SpanID
+	var flags [1]byte
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || !traceID.IsValid() {
+		return TraceID{}, SpanID{}, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || !parentID.IsValid() {
+		return TraceID{}, SpanID{}, false, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return TraceID{}, SpanID{}, false, false
+	}
+	return traceID, parentID, flags[0]&1 == 1, true
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
+
+// InMemoryExporter keeps every span it's given, for tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpan(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the spans exported so far, in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData{}, e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTracing(t *testing.T) {
+	Convey("a span is exported for every call", t, func() {
+		type greetRequest struct {
+			Name string `validate:"nonzero"`
+		}
+		type greetResponse struct{ Greeting string }
+		type lookupRequest struct{}
+		type lookupResponse struct{ TraceParent string }
+		type explodeRequest struct{}
+		type explodeResponse struct{}
+
+		greetFn := func(ctx context.Context, req greetRequest) (*greetResponse, error) {
+			return &greetResponse{Greeting: "hi " + req.Name}, nil
+		}
+		lookupFn := func(ctx context.Context, req lookupRequest) (*lookupResponse, error) {
+			ctx, span := StartSpan(ctx, "db.query")
+			defer span.End()
+			span.SetAttribute("db.table", "users")
+			return &lookupResponse{TraceParent: TraceParent(ctx)}, nil
+		}
+		explodeFn := func(ctx context.Context, req explodeRequest) (*explodeResponse, error) {
+			panic("boom")
+		}
+
+		exporter := NewInMemoryExporter()
+		a := New("", "", WithTracing(exporter), WithBatching(0), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+		NewRoute(greetFn).Attach(a)
+		NewRoute(greetFn).Attach(a.Group("admin"))
+		NewRoute(lookupFn).Attach(a)
+		NewRoute(explodeFn).Attach(a)
+
+		call := func(path string, body string, traceparent string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+			if traceparent != "" {
+				r.Header.Set("traceparent", traceparent)
+			}
+			w := httptest.NewRecorder()
+			a.Handler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("named after the route, with its status and sizes", func() {
+			body := `{"Name": "sam"}`
+			w := call("/tinyrpc/greet", body, "")
+			call("/tinyrpc/greet", `{}`, "")
+
+			spans := exporter.Spans()
+			So(spans, ShouldHaveLength, 2)
+			So(spans[0].Name, ShouldEqual, "greet")
+			So(spans[0].Status, ShouldEqual, STATUS_OK)
+			So(spans[0].TraceID.IsValid(), ShouldBeTrue)
+			So(spans[0].SpanID.IsValid(), ShouldBeTrue)
+			So(spans[0].ParentSpanID.IsValid(), ShouldBeFalse)
+			So(spans[0].End, ShouldHappenOnOrAfter, spans[0].Start)
+			So(spans[0].Attributes["tinyrpc.method"], ShouldEqual, "greet")
+			So(spans[0].Attributes["tinyrpc.status"], ShouldEqual, "STATUS_OK")
+			So(spans[0].Attributes["tinyrpc.request_bytes"], ShouldEqual, len(body))
+			So(spans[0].Attributes["tinyrpc.response_bytes"], ShouldEqual, w.Body.Len())
+
+			So(spans[1].Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
+			So(spans[1].TraceID, ShouldNotEqual, spans[0].TraceID)
+		})
+
+		Convey("as part of the caller's trace", func() {
+			call("/tinyrpc/greet", `{"Name": "sam"}`, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+			spans := exporter.Spans()
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].TraceID.String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(spans[0].ParentSpanID.String(), ShouldEqual, "00f067aa0ba902b7")
+		})
+
+		Convey("starting a new trace if the traceparent is invalid", func() {
+			call("/tinyrpc/greet", `{"Name": "sam"}`, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+
+			spans := exporter.Spans()
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].TraceID.IsValid(), ShouldBeTrue)
+			So(spans[0].ParentSpanID.IsValid(), ShouldBeFalse)
+		})
+
+		Convey("unless the caller's trace isn't sampled", func() {
+			call("/tinyrpc/greet", `{"Name": "sam"}`, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+			So(exporter.Spans(), ShouldBeEmpty)
+		})
+
+		Convey("with child spans started by the handler", func() {
+			w := call("/tinyrpc/lookup", `{}`, "")
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			spans := exporter.Spans()
+			So(spans, ShouldHaveLength, 2)
+			child, parent := spans[0], spans[1]
+			So(child.Name, ShouldEqual, "db.query")
+			So(child.TraceID, ShouldEqual, parent.TraceID)
+			So(child.ParentSpanID, ShouldEqual, parent.SpanID)
+			So(child.Attributes["db.table"], ShouldEqual, "users")
+			So(w.Body.String(), ShouldContainSubstring, "00-"+child.TraceID.String()+"-"+child.SpanID.String()+"-01")
+		})
+
+		Convey("with a span for each call in a batch", func() {
+			call("/tinyrpc/$batch", `[{"Method": "greet", "Params": {"Name": "a"}}, {"Method": "admin.greet", "Params": {"Name": "b"}}]`,
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+			spans := exporter.Spans()
+			So(spans, ShouldHaveLength, 2)
+			names := []string{spans[0].Attributes["tinyrpc.method"].(string), spans[1].Attributes["tinyrpc.method"].(string)}
+			So(names, ShouldContain, "greet")
+			So(names, ShouldContain, "admin.greet")
+			So(spans[0].TraceID, ShouldEqual, spans[1].TraceID)
+		})
+
+		Convey("with panics recorded as internal errors", func() {
+			call("/tinyrpc/explode", `{}`, "")
+
+			spans := exporter.Spans()
+			So(spans, ShouldHaveLength, 1)
+			So(spans[0].Status, ShouldEqual, STATUS_INTERNAL)
+		})
+	})
+
+	Convey("spans are safe to use when the request isn't traced", t, func() {
+		ctx, span := StartSpan(context.Background(), "work")
+		So(span, ShouldBeNil)
+		span.SetAttribute("key", "value")
+		span.SetStatus(STATUS_INTERNAL)
+		span.End()
+		So(span.TraceID().IsValid(), ShouldBeFalse)
+		So(TraceParent(ctx), ShouldBeEmpty)
+	})
+
+	Convey("the client can send a traceparent", t, func() {
+		type greetRequest struct{}
+		type greetResponse struct{}
+		greetFn := func(ctx context.Context, req greetRequest) (*greetResponse, error) {
+			return &greetResponse{}, nil
+		}
+
+		Convey("if tracing is on", func() {
+			a := New("", "", WithTracing(NewInMemoryExporter()))
+			NewRoute(greetFn).Attach(a)
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldContainSubstring, "export function setTracing(enabled: boolean): void")
+			So(code, ShouldContainSubstring, "function newTraceparent(): string")
+			So(code, ShouldContainSubstring, `r.set("traceparent", traceparent);`)
+			So(code, ShouldContainSubstring, "traceparent?: string;")
+			So(code, ShouldContainSubstring, "let tracing = false;")
+		})
+
+		Convey("but not otherwise", func() {
+			a := New("", "")
+			NewRoute(greetFn).Attach(a)
+			code, err := a.genCode()
+			So(err, ShouldBeNil)
+			So(code, ShouldNotContainSubstring, "setTracing")
+			So(code, ShouldNotContainSubstring, "traceparent")
+		})
+	})
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/concolorcarne/tinyrpc/typescriptify"
+	"gopkg.in/validator.v2"
+)
+
+// FieldViolation describes a single field that failed validation
+type FieldViolation struct {
+	// Path to the field using the same names as the generated Typescript,
+	// e.g. "Items[0].input_name"
+	Field string
+	// The validation rule that was broken, e.g. "nonzero" or "max"
+	Rule    string
+	Message string
+}
+
+var errRequired = validator.TextErr{Err: errors.New("required")}
+
+// validator doesn't know "required", but the generated Typescript treats it as
+// marking a field as required, so here it means the same as "nonzero"
+var requestValidator = func() *validator.Validator {
+	v := validator.NewValidator()
+	v.SetValidationFunc("required", func(value any, _ string) error {
+		if validator.Valid(value, "nonzero") != nil {
+			return errRequired
+		}
+		return nil
+	})
+	return v
+}()
+
+// Validate the decoded request, turning validator's errors into an
+// INVALID_ARGUMENT error with a violation per broken rule
+func validateRequest(body any) error {
+	err := requestValidator.Validate(body)
+	if err == nil {
+		return nil
+	}
+
+	var errMap validator.ErrorMap
+	if !errors.As(err, &errMap) {
+		return NewError(STATUS_INVALID_ARGUMENT, err.Error())
+	}
+
+	bodyType := reflect.TypeOf(body)
+	violations := []FieldViolation{}
+	for path, fieldErrs := range errMap {
+		field := jsonFieldPath(bodyType, path)
+		for _, fieldErr := range fieldErrs {
+			violations = append(violations, FieldViolation{
+				Field:   field,
+				Rule:    violatedRule(fieldErr),
+				Message: fieldErr.Error(),
+			})
+		}
+	}
+	// Map iteration order is random, so keep the output stable
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Field < violations[j].Field
+	})
+
+	return &Error{
+		Status:     STATUS_INVALID_ARGUMENT,
+		Message:    err.Error(),
+		Violations: violations,
+	}
+}
+
+func violatedRule(err error) string {
+	switch err {
+	case validator.ErrZeroValue:
+		return "nonzero"
+	case errRequired:
+		return "required"
+	case validator.ErrMin:
+		return "min"
+	case validator.ErrMax:
+		return "max"
+	case validator.ErrLen:
+		return "len"
+	case validator.ErrRegexp:
+		return "regexp"
+	case validator.ErrUnknownTag:
+		return "unknown_tag"
+	case validator.ErrBadParameter:
+		return "bad_parameter"
+	default:
+		return "invalid"
+	}
+}
+
+// Translate validator's path to the field, which uses the Go field names (e.g.
+// "Items[0].Name"), into one that uses the JSON names the client knows about
+func jsonFieldPath(typ reflect.Type, path string) string {
+	parts := []string{}
+	for _, segment := range strings.Split(path, ".") {
+		name, suffix := segment, ""
+		if idx := strings.Index(segment, "["); idx >= 0 {
+			name, suffix = segment[:idx], segment[idx:]
+		}
+
+		typ = derefType(typ)
+		if typ == nil || typ.Kind() != reflect.Struct {
+			// Lost track of the type, so the rest of the path is left as is
+			typ = nil
+			parts = append(parts, segment)
+			continue
+		}
+
+		field, found := typ.FieldByName(name)
+		if !found {
+			typ = nil
+			parts = append(parts, segment)
+			continue
+		}
+
+		// Embedded structs are flattened into their parent by encoding/json
+		if !field.Anonymous || field.Tag.Get("json") != "" {
+			parts = append(parts, typescriptify.JSONFieldName(field, "json")+suffix)
+		}
+		typ = descendIndexes(field.Type, suffix)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Follow the element type for each index in a suffix like "[0][1]" or the key
+// type for a map key suffix like "[name](key)"
+func descendIndexes(typ reflect.Type, suffix string) reflect.Type {
+	if suffix == "" {
+		return typ
+	}
+	for _, afterIndex := range strings.Split(suffix, "]")[1:] {
+		typ = derefType(typ)
+		if typ == nil {
+			return nil
+		}
+		switch {
+		case typ.Kind() == reflect.Map && strings.HasPrefix(afterIndex, "(key)"):
+			typ = typ.Key()
+		case typ.Kind() == reflect.Map || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array:
+			typ = typ.Elem()
+		default:
+			return nil
+		}
+	}
+	return typ
+}
+
+func derefType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
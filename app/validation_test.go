@@ -0,0 +1,49 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidationViolations(t *testing.T) {
+	Convey("validation errors are broken down per field", t, func() {
+		type addressItem struct {
+			Postcode string `validate:"nonzero" json:"post_code"`
+		}
+		type baseFields struct {
+			ID int `validate:"min=1"`
+		}
+		type createUserRequest struct {
+			baseFields
+			Name      string        `validate:"nonzero,max=3" json:"user_name"`
+			Addresses []addressItem `json:"addresses"`
+			Primary   *addressItem
+		}
+
+		err := validateRequest(createUserRequest{
+			Name:      "toolong",
+			Addresses: []addressItem{{Postcode: "1234"}, {}},
+			Primary:   &addressItem{},
+		})
+		So(err, ShouldNotBeNil)
+
+		var rpcErr *Error
+		So(errors.As(err, &rpcErr), ShouldBeTrue)
+		So(rpcErr.Status, ShouldEqual, STATUS_INVALID_ARGUMENT)
+		So(rpcErr.Violations, ShouldResemble, []FieldViolation{
+			{Field: "ID", Rule: "min", Message: "less than min"},
+			{Field: "Primary.post_code", Rule: "nonzero", Message: "zero value"},
+			{Field: "addresses[1].post_code", Rule: "nonzero", Message: "zero value"},
+			{Field: "user_name", Rule: "max", Message: "greater than max"},
+		})
+	})
+
+	Convey("valid requests have no violations", t, func() {
+		type pingRequest struct {
+			Name string `validate:"nonzero"`
+		}
+		So(validateRequest(pingRequest{Name: "ok"}), ShouldBeNil)
+	})
+}
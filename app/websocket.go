@@ -0,0 +1,327 @@
+package app
+
+// A minimal server side implementation of the WebSocket protocol (RFC 6455),
+// covering what socket routes need: the opening handshake, text messages
+// (including fragmented ones), ping/ pong and the closing handshake.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+const (
+	wsCloseNormal          = 1000
+	wsCloseProtocolError   = 1002
+	wsCloseUnsupportedData = 1003
+	wsCloseInvalidPayload  = 1007
+	wsCloseMessageTooBig   = 1009
+	// Application close codes are 4000 + the Status of the error that ended
+	// the connection, so the client can decide whether to reconnect
+	wsCloseStatusBase = 4000
+)
+
+// Control frames can't be fragmented, and their payload is limited to 125 bytes
+const wsMaxControlPayload = 125
+
+// Appended to the client's key to prove the server understood the handshake
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var errSocketClosed = errors.New("websocket closed")
+
+type wsConn struct {
+	conn            net.Conn
+	reader          *bufio.Reader
+	maxMessageBytes int64
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsAcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Check the opening handshake and take over the connection. Until the
+// connection is hijacked, failures can still be sent as a normal response.
+// Messages from the client are limited to maxMessageBytes, zero or less means
+// no limit.
+func upgradeWebSocket(w http.ResponseWriter, req *http.Request, maxMessageBytes int64) (*wsConn, error) {
+	if req.Method != http.MethodGet {
+		return nil, NewError(STATUS_INVALID_ARGUMENT, "websocket requests must use GET")
+	}
+	if !headerContainsToken(req.Header, "Connection", "upgrade") || !headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return nil, NewError(STATUS_INVALID_ARGUMENT, "expected a websocket upgrade request")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, NewError(STATUS_INVALID_ARGUMENT, "unsupported websocket version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, NewError(STATUS_INVALID_ARGUMENT, "missing Sec-WebSocket-Key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, Errorf(STATUS_INTERNAL, "unable to take over connection: %v", err)
+	}
+	// The server's timeouts are meant for regular requests
+	conn.SetDeadline(time.Time{})
+
+	// Anything the handler set on the response so far goes out with the 101
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	headers := w.Header().Clone()
+	headers.Set("Upgrade", "websocket")
+	headers.Set("Connection", "Upgrade")
+	headers.Set("Sec-WebSocket-Accept", wsAcceptKey(key))
+	headers.Write(&response)
+	response.WriteString("\r\n")
+
+	if _, err := rw.WriteString(response.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{
+		conn:            conn,
+		reader:          rw.Reader,
+		maxMessageBytes: maxMessageBytes,
+	}, nil
+}
+
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func (c *wsConn) readFrame() (wsFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return wsFrame{}, err
+	}
+
+	frame := wsFrame{
+		fin:    header[0]&0x80 != 0,
+		opcode: header[0] & 0x0F,
+	}
+	if header[0]&0x70 != 0 {
+		return frame, c.fail(wsCloseProtocolError, "unexpected reserved bits")
+	}
+	// Clients always have to mask their frames
+	if header[1]&0x80 == 0 {
+		return frame, c.fail(wsCloseProtocolError, "client frames must be masked")
+	}
+
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame, err
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]) & (1<<63 - 1))
+	}
+	if frame.opcode&0x8 != 0 {
+		if !frame.fin {
+			return frame, c.fail(wsCloseProtocolError, "control frames can't be fragmented")
+		}
+		if length > wsMaxControlPayload {
+			return frame, c.fail(wsCloseProtocolError, "control frames are limited to 125 bytes")
+		}
+	}
+	if c.maxMessageBytes > 0 && length > c.maxMessageBytes {
+		return frame, c.fail(wsCloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return frame, err
+	}
+	// Without a limit, the length can't be trusted to allocate up front
+	payload, err := io.ReadAll(io.LimitReader(c.reader, length))
+	if err != nil {
+		return frame, err
+	}
+	if int64(len(payload)) < length {
+		return frame, io.ErrUnexpectedEOF
+	}
+	frame.payload = payload
+	for idx := range frame.payload {
+		frame.payload[idx] ^= mask[idx%4]
+	}
+	return frame, nil
+}
+
+// Read the next complete text message, dealing with any control frames that
+// arrive in between. Returns io.EOF once the client has closed the connection.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	inMessage := false
+
+	for {
+		frame, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil, errSocketClosed
+			}
+			return nil, err
+		}
+
+		switch frame.opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, frame.payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.close(wsCloseNormal, "")
+			return nil, io.EOF
+		case wsOpBinary:
+			return nil, c.fail(wsCloseUnsupportedData, "only text messages are supported")
+		case wsOpText:
+			if inMessage {
+				return nil, c.fail(wsCloseProtocolError, "expected a continuation frame")
+			}
+			inMessage = true
+		case wsOpContinuation:
+			if !inMessage {
+				return nil, c.fail(wsCloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return nil, c.fail(wsCloseProtocolError, "unknown opcode")
+		}
+
+		message = append(message, frame.payload...)
+		if c.maxMessageBytes > 0 && int64(len(message)) > c.maxMessageBytes {
+			return nil, c.fail(wsCloseMessageTooBig, "message too big")
+		}
+		if frame.fin {
+			// Fragments can split characters, so only the whole message is checked
+			if !utf8.Valid(message) {
+				return nil, c.fail(wsCloseInvalidPayload, "text messages must be valid UTF-8")
+			}
+			return message, nil
+		}
+	}
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return errSocketClosed
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) writeText(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+// Send the close frame and drop the connection. Only the first call has any
+// effect.
+func (c *wsConn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		// Control frames are limited to 125 bytes, 2 of which are the code
+		// The reason has to stay valid UTF-8, so it's cut at the start of a rune
+		if len(reason) > 123 {
+			end := 123
+			for end > 0 && !utf8.RuneStart(reason[end]) {
+				end--
+			}
+			reason = reason[:end]
+		}
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(wsOpClose, payload)
+		c.conn.Close()
+	})
+}
+
+// Close the connection because the client broke the protocol
+func (c *wsConn) fail(code int, reason string) error {
+	c.close(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
+
+// Send the error to the client as a Res[ReturnError] message, then close the
+// connection with a code derived from its status
+func (c *wsConn) closeWithError(err error) {
+	data, marshalErr := buildHandlerError(err)
+	if marshalErr != nil {
+		data, _ = buildError(STATUS_INTERNAL, marshalErr.Error())
+	}
+	c.writeText(data)
+
+	status := StatusFromError(err)
+	var rpcErr *Error
+	message := err.Error()
+	if errors.As(err, &rpcErr) {
+		message = rpcErr.Message
+	}
+	c.close(wsCloseStatusBase+int(status), message)
+}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/concolorcarne/tinyrpc/app"
 )
@@ -25,6 +31,9 @@ type getDirContentsResponse struct {
 
 func getDirContents(ctx context.Context, req getDirContentsRequest) (*getDirContentsResponse, error) {
 	dirContents, err := os.ReadDir(req.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, app.NewError(app.STATUS_NOT_FOUND, "directory doesn't exist")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error looking up directory: %v", err)
 	}
@@ -60,14 +69,21 @@ const secretTokenValue = "123456"
 func GetTokenMiddleware(ctx context.Context, req any, method string, handler app.MiddlewareHandler) (any, error) {
 	token := app.GetHeader(ctx, "token")
 	if token != secretTokenValue {
-		return nil, fmt.Errorf("invalid secret token")
+		return nil, app.NewError(app.STATUS_UNAUTHENTICATED, "invalid secret token")
 	}
 	return handler(ctx, req)
 }
 func main() {
-	a := app.New("localhost:8000", "./output.ts")
+	// Listing large directories can take a while, so give the handlers
+	// longer than the default to respond
+	a := app.New("localhost:8000", "./output.ts", app.WithWriteTimeout(time.Minute))
 	app.NewRoute(getDirContents).AttachWithMiddleware(a, GetTokenMiddleware)
 	app.NewRoute(sayHelloHandler).Attach(a)
 
-	a.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := a.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
 }
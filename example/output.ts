@@ -41,54 +41,123 @@ export interface sayHelloResponse {
     Message: string;
 }
 
-export async function getDirContents(params: getDirContentsRequest, headers?: HeadersInit): Promise<Response<getDirContentsResponse> | Error> {
-	return genFunc<getDirContentsRequest, getDirContentsResponse>(params, "/tinyrpc/getDirContents", headers);
+export async function getDirContents(params: getDirContentsRequest, headers?: HeadersInit, options?: CallOptions): Promise<Response<getDirContentsResponse> | Error> {
+	return genFunc<getDirContentsRequest, getDirContentsResponse>(params, "/tinyrpc/getDirContents", headers, options);
 }
 
-export async function sayHello(params: sayHelloRequest, headers?: HeadersInit): Promise<Response<sayHelloResponse> | Error> {
-	return genFunc<sayHelloRequest, sayHelloResponse>(params, "/tinyrpc/sayHello", headers);
+export async function sayHello(params: sayHelloRequest, headers?: HeadersInit, options?: CallOptions): Promise<Response<sayHelloResponse> | Error> {
+	return genFunc<sayHelloRequest, sayHelloResponse>(params, "/tinyrpc/sayHello", headers, options);
 }
 
-async function genFunc<T, K>(params: T, path: string, headers?: HeadersInit): Promise<Error | Response<K>> {
-	const requestOptions: RequestInit = { method: "POST" };
+async function genFunc<T, K>(params: T, path: string, headers?: HeadersInit, options?: CallOptions): Promise<Error | Response<K>> {
+	const signal = callSignal(options);
+	const requestOptions: RequestInit = { method: "POST", signal: signal };
 	requestOptions.body = JSON.stringify(params as T);
-	requestOptions.headers = headers;
+	requestOptions.headers = requestHeaders(headers, options);
 	
 	const host = "http://localhost:8000";
 	const url = host + path;
 	let res;
 	try { res = await fetch(url, requestOptions); }
 	catch (e) {
+		if (timedOut(signal, options)) { return { Message: "Deadline exceeded", Status: Status.STATUS_DEADLINE_EXCEEDED, IsError: true } as Error; }
+		if (signal?.aborted) { return { Message: "Request cancelled", Status: Status.STATUS_CANCELLED, IsError: true } as Error; }
 		return { Message: "Likely network error: " + e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error;
 	}
 	let body;
 	try { body = await res.json(); }
 	catch (e) {
-		// couldn't cast to JSON
-		return { Message: e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error;
+		if (timedOut(signal, options)) { return { Message: "Deadline exceeded", Status: Status.STATUS_DEADLINE_EXCEEDED, IsError: true } as Error; }
+		// couldn't cast to JSON, so the HTTP status is all we've got
+		const status = res.ok ? Status.STATUS_UNAVAILABLE : statusFromHTTPCode(res.status);
+		return { Message: "Unable to parse response (HTTP " + res.status + "): " + e, Status: status, RetryAfter: retryAfter(res.headers), IsError: true } as Error;
 	}
 	// Check if it's an application error and try build into an Error response
 	let innerBody = body["Body"];
 	if (innerBody !== undefined && innerBody["ErrorMessage"] !== undefined) {
 		try {
-			let r = body as Response<ErrorRes>;
-			return { Message: r.Body.ErrorMessage, Status: r.Status, IsError: true } as Error;
+			const err = toError(body as Response<ErrorRes>);
+			err.Headers = res.headers;
+			return err;
 		} catch (e) {
 			return { Message: e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error
 		}
 	}
+	if (!res.ok) {
+		return { Message: "Unexpected response: HTTP " + res.status + " " + res.statusText, Status: statusFromHTTPCode(res.status), RetryAfter: retryAfter(res.headers), Headers: res.headers, IsError: true } as Error;
+	}
 	try {
 		let r = body as Response<K>;
+		r.Headers = res.headers;
 		return r;
 	} catch (e) {
 		return { Message: e, Status: Status.STATUS_UNAVAILABLE, IsError: true } as Error
 	}
 }
 
+function requestHeaders(headers?: HeadersInit, options?: CallOptions): Headers {
+	const r = new Headers(headers);
+	if (options?.timeout !== undefined) {
+		const ms = Math.max(1, Math.ceil(options.timeout));
+		r.set("Tinyrpc-Timeout", ms < 1e8 ? ms + "m" : Math.ceil(ms / 1000) + "S");
+	}
+	return r;
+}
+
+function callSignal(options?: CallOptions): AbortSignal | undefined {
+	if (options?.timeout === undefined) { return options?.signal; }
+	const controller = new AbortController();
+	const timer = setTimeout(() => controller.abort(), options.timeout);
+	const signal = options.signal;
+	if (signal !== undefined) {
+		const abort = () => { clearTimeout(timer); controller.abort(); };
+		if (signal.aborted) { abort(); }
+		signal.addEventListener("abort", abort, { once: true });
+	}
+	return controller.signal;
+}
+
+function timedOut(signal: AbortSignal | undefined, options?: CallOptions): boolean {
+	return signal?.aborted === true && options?.timeout !== undefined && options.signal?.aborted !== true;
+}
+
+function retryAfter(headers: Headers): number | undefined {
+	const value = headers.get("Retry-After");
+	if (value === null) { return undefined; }
+	const seconds = Number(value);
+	if (!isNaN(seconds)) { return seconds * 1000; }
+	const date = Date.parse(value);
+	return isNaN(date) ? undefined : Math.max(0, date - Date.now());
+}
+
+function toError(r: Response<ErrorRes>): Error {
+	return { Message: r.Body.ErrorMessage, Status: r.Status, Details: r.Body.Details, Violations: r.Body.Violations, RetryAfter: r.Body.RetryAfter, IsError: true } as Error;
+}
+
+function statusFromHTTPCode(code: number): Status {
+	switch (code) {
+		case 499: return Status.STATUS_CANCELLED;
+		case 500: return Status.STATUS_UNKNOWN;
+		case 400: return Status.STATUS_INVALID_ARGUMENT;
+		case 504: return Status.STATUS_DEADLINE_EXCEEDED;
+		case 404: return Status.STATUS_NOT_FOUND;
+		case 409: return Status.STATUS_ALREADY_EXISTS;
+		case 403: return Status.STATUS_PERMISSION_DENIED;
+		case 429: return Status.STATUS_RESOURCE_EXHAUSTED;
+		case 501: return Status.STATUS_UNIMPLEMENTED;
+		case 503: return Status.STATUS_UNAVAILABLE;
+		case 401: return Status.STATUS_UNAUTHENTICATED;
+		case 413: return Status.STATUS_RESOURCE_EXHAUSTED;
+		default: return Status.STATUS_UNKNOWN;
+	}
+}
+
 export function isError(possibleError: Error | Response<any>): possibleError is Error {
 	return (possibleError as Error).IsError !== undefined;
 }
 
 export interface Response<T> { Body: T; Status: Status; Headers: Headers; }
-export interface Error { Message: String; IsError: boolean; Status: Status; }
-export interface ErrorRes { ErrorMessage: String }
+export interface Error { Message: String; IsError: boolean; Status: Status; Details?: any; Violations?: FieldViolation[]; RetryAfter?: number; Headers?: Headers; }
+export interface ErrorRes { ErrorMessage: String; Details?: any; Violations?: FieldViolation[]; RetryAfter?: number; }
+export interface FieldViolation { Field: string; Rule: string; Message: string; }
+export interface CallOptions { signal?: AbortSignal; timeout?: number; }
@@ -4,7 +4,9 @@ package typescriptify
 
 import (
 	"fmt"
+	"log/slog"
 	"reflect"
+	"regexp"
 	"strings"
 
 	"github.com/tkrajina/go-reflector/reflector"
@@ -58,12 +60,18 @@ type FunctionParameter struct {
 }
 
 type TypeScriptFunction struct {
-	IsAsync    bool
-	Name       string
-	Parameters []FunctionParameter
-	ReturnType string
-	Body       []string
-	DontExport bool
+	IsAsync bool
+	// Generator functions are written as function*, which combined with
+	// IsAsync gives an async generator
+	IsGenerator bool
+	Name        string
+	Parameters  []FunctionParameter
+	ReturnType  string
+	Body        []string
+	DontExport  bool
+	// Dotted namespace to nest the function in, e.g. "admin.users". Functions
+	// with no namespace are written at the top level
+	Namespace string
 }
 
 type enumElement struct {
@@ -80,7 +88,8 @@ type TypeScriptify struct {
 	DontExport        bool
 	CreateInterface   bool
 	CustomJsonTag     string
-	Quiet             bool // surpress logs when building output
+	Quiet             bool         // surpress logs when building output
+	Logger            *slog.Logger // if set, logs go here at debug level rather than stdout
 	customImports     []string
 
 	structTypes []StructType
@@ -156,9 +165,14 @@ func deepFields(typeOf reflect.Type) []reflect.StructField {
 }
 
 func (ts TypeScriptify) logf(depth int, s string, args ...interface{}) {
-	if !ts.Quiet {
-		fmt.Printf(strings.Repeat("   ", depth)+s+"\n", args...)
+	if ts.Quiet {
+		return
 	}
+	if ts.Logger != nil {
+		ts.Logger.Debug(fmt.Sprintf(s, args...), "depth", depth)
+		return
+	}
+	fmt.Printf(strings.Repeat("   ", depth)+s+"\n", args...)
 }
 
 func (t *TypeScriptify) AddFunction(funcDef TypeScriptFunction) *TypeScriptify {
@@ -270,12 +284,33 @@ func (t *TypeScriptify) Convert(customCode map[string]string) (string, error) {
 		result += "\n" + strings.Trim(typeScriptCode, " "+t.Indent+"\r\n") + "\n"
 	}
 
+	// Namespaced functions are collected up and written out together after the
+	// top level functions, in the order the namespaces were first seen
+	namespaces := []string{}
+	namespacedCode := map[string][]string{}
 	for _, funcDef := range t.functions {
 		typeScriptCode, err := t.convertFunction(depth, funcDef)
 		if err != nil {
 			return "", err
 		}
-		result += "\n" + strings.Trim(typeScriptCode, " "+t.Indent+"\r\n") + "\n"
+		typeScriptCode = strings.Trim(typeScriptCode, " "+t.Indent+"\r\n")
+
+		if funcDef.Namespace == "" {
+			result += "\n" + typeScriptCode + "\n"
+			continue
+		}
+		if _, found := namespacedCode[funcDef.Namespace]; !found {
+			namespaces = append(namespaces, funcDef.Namespace)
+		}
+		namespacedCode[funcDef.Namespace] = append(namespacedCode[funcDef.Namespace], indentLines(typeScriptCode, 1))
+	}
+
+	for _, namespace := range namespaces {
+		exportString := ""
+		if !t.DontExport {
+			exportString = "export "
+		}
+		result += fmt.Sprintf("\n%snamespace %s {\n%s\n}\n", exportString, namespace, strings.Join(namespacedCode[namespace], "\n\n"))
 	}
 
 	return result, nil
@@ -318,10 +353,16 @@ func (t *TypeScriptify) convertFunction(depth int, funcDef TypeScriptFunction) (
 		lines += indentLines(line, 1) + "\n"
 	}
 
+	generatorString := ""
+	if funcDef.IsGenerator {
+		generatorString = "*"
+	}
+
 	funcCode := fmt.Sprintf(
-		"%s%sfunction %s(%s): %s {\n%s}\n",
+		"%s%sfunction%s %s(%s): %s {\n%s}\n",
 		exportString,
 		asyncString,
+		generatorString,
 		funcDef.Name,
 		strings.Join(params, ", "),
 		funcDef.ReturnType,
@@ -396,25 +437,66 @@ func (t *TypeScriptify) getFieldOptions(structType reflect.Type, field reflect.S
 	return opts
 }
 
+// JSONFieldName returns the name a struct field is marshalled to under the
+// given tag (usually "json"), which is also the name used for it in the
+// generated interfaces
+func JSONFieldName(field reflect.StructField, tag string) string {
+	jsonTag := field.Tag.Get(tag)
+	if len(jsonTag) == 0 {
+		return field.Name
+	}
+	name := strings.TrimSpace(strings.Split(jsonTag, ",")[0])
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// IsRequiredField reports whether the field's validate tag makes it required,
+// with either "required" or "nonzero". Those fields aren't optional in the
+// generated interfaces.
+func IsRequiredField(field reflect.StructField) bool {
+	for _, rule := range strings.Split(field.Tag.Get(validateTagName), ",") {
+		if rule = strings.TrimSpace(rule); rule == "required" || rule == "nonzero" {
+			return true
+		}
+	}
+	return false
+}
+
+// Package paths in the type arguments of generic types, e.g. the "main." in
+// "Page[main.User]"
+var packageQualifier = regexp.MustCompile(`[A-Za-z0-9_./-]*\.`)
+
+var nonIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_$]+`)
+
+// TypeName returns the name a type is given in the generated code. This is
+// just its Go name, except for instantiated generic types, whose type
+// arguments are appended, e.g. Page[main.User] becomes Page_User.
+func TypeName(typeOf reflect.Type) string {
+	name := typeOf.Name()
+	if !strings.Contains(name, "[") {
+		return name
+	}
+	name = packageQualifier.ReplaceAllString(name, "")
+	name = nonIdentifierChars.ReplaceAllString(name, "_")
+	return strings.TrimSuffix(name, "_")
+}
+
 func (t *TypeScriptify) getJSONFieldName(field reflect.StructField, isPtr bool) string {
-	jsonFieldName := field.Name
 	tag := jsonTag
 	if t.CustomJsonTag != "" {
 		tag = t.CustomJsonTag
 	}
+	jsonFieldName := JSONFieldName(field, tag)
 	jsonTag := field.Tag.Get(tag)
-	validateTag := field.Tag.Get(validateTagName)
-
-	markedAsRequired := false
+	markedAsRequired := IsRequiredField(field)
 	hasOmitEmpty := false
 	ignored := false
 
 	// We've found a json tag, handle this
 	if len(jsonTag) > 0 {
 		jsonTagParts := strings.Split(jsonTag, ",")
-		if len(jsonTagParts) > 0 {
-			jsonFieldName = strings.Trim(jsonTagParts[0], t.Indent)
-		}
 
 		for _, t := range jsonTagParts {
 			if t == "" {
@@ -432,19 +514,6 @@ func (t *TypeScriptify) getJSONFieldName(field reflect.StructField, isPtr bool)
 
 	}
 
-	// We've found a validator tag, see if it's marked as required
-	if len(validateTag) > 0 {
-		for _, t := range strings.Split(validateTag, ",") {
-			if t == "" {
-				break
-			}
-			if t == "required" {
-				markedAsRequired = true
-				break
-			}
-		}
-	}
-
 	// How do we want to deal with this? There's potentially conflicting instructions?
 	if !ignored && isPtr || hasOmitEmpty || !markedAsRequired {
 		jsonFieldName = fmt.Sprintf("%s?", jsonFieldName)
@@ -461,7 +530,7 @@ func (t *TypeScriptify) convertType(depth int, typeOf reflect.Type, customCode m
 
 	t.alreadyConverted[typeOf] = true
 
-	entityName := t.Prefix + typeOf.Name() + t.Suffix
+	entityName := t.Prefix + TypeName(typeOf) + t.Suffix
 	result := ""
 	if t.CreateInterface {
 		result += fmt.Sprintf("interface %s {\n", entityName)
@@ -674,14 +743,14 @@ func (t *typeScriptClassBuilder) AddEnumField(fieldName string, field reflect.St
 }
 
 func (t *typeScriptClassBuilder) AddStructField(fieldName string, field reflect.StructField) {
-	fieldType := field.Type.Name()
+	fieldType := TypeName(field.Type)
 	strippedFieldName := strings.ReplaceAll(fieldName, "?", "")
 	t.addField(fieldName, t.prefix+fieldType+t.suffix)
 	t.addInitializerFieldLine(strippedFieldName, fmt.Sprintf("this.convertValues(source[\"%s\"], %s)", strippedFieldName, t.prefix+fieldType+t.suffix))
 }
 
 func (t *typeScriptClassBuilder) AddArrayOfStructsField(fieldName string, field reflect.StructField, arrayDepth int) {
-	fieldType := field.Type.Elem().Name()
+	fieldType := TypeName(field.Type.Elem())
 	strippedFieldName := strings.ReplaceAll(fieldName, "?", "")
 	t.addField(fieldName, fmt.Sprint(t.prefix+fieldType+t.suffix, strings.Repeat("[]", arrayDepth)))
 	t.addInitializerFieldLine(strippedFieldName, fmt.Sprintf("this.convertValues(source[\"%s\"], %s)", strippedFieldName, t.prefix+fieldType+t.suffix))